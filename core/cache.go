@@ -0,0 +1,174 @@
+package pickett
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+//CACHE_VOLUME_PREFIX starts the name of every docker volume that holds a build cache.
+const CACHE_VOLUME_PREFIX = "pickett-cache-"
+
+//CACHE_DIR is where caches that only give an Env are mounted.
+const CACHE_DIR = "/pickett/caches"
+
+//GO_MODULE_CACHE_KEY is the Key of the cache that builds and tests in module mode keep the
+//modules they download in, so they are not downloaded again every time.
+const GO_MODULE_CACHE_KEY = "gomodcache"
+
+//cacheMount is a build cache of a go build or test: a docker volume that outlives the
+//containers it is mounted in.  Builds that use the same key share the volume.
+type cacheMount struct {
+	key    string
+	volume string
+	dir    string
+	env    string
+}
+
+//cacheKey turns s into something that can be part of the name of a docker volume.
+func cacheKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, s)
+}
+
+//newCacheMounts checks the caches of the build or test called owner and fills in
+//the defaults.  A cache without a Key belongs to owner alone.
+func newCacheMounts(owner string, caches []*Cache) ([]*cacheMount, error) {
+	result := []*cacheMount{}
+	seen := make(map[string]bool)
+	for _, c := range caches {
+		m := &cacheMount{
+			env: strings.Trim(c.Env, " \n"),
+			dir: strings.Trim(c.MountedAt, " \n"),
+		}
+		if m.dir == "" {
+			if m.env == "" {
+				return nil, fmt.Errorf("cache of %s needs MountedAt or Env", owner)
+			}
+			m.dir = CACHE_DIR + "/" + strings.ToLower(m.env)
+		}
+		if !filepath.IsAbs(m.dir) {
+			return nil, fmt.Errorf("cache of %s must be mounted at an absolute path, not %s", owner, m.dir)
+		}
+		m.dir = filepath.Clean(m.dir)
+		if seen[m.dir] {
+			return nil, fmt.Errorf("%s has two caches mounted at %s", owner, m.dir)
+		}
+		seen[m.dir] = true
+		m.key = cacheKey(strings.Trim(c.Key, " \n"))
+		if m.key == "" {
+			m.key = cacheKey(owner + "-" + filepath.Base(m.dir))
+		}
+		m.volume = CACHE_VOLUME_PREFIX + m.key
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+//withModuleCache returns caches with the module cache added, for a build or test in module
+//mode, unless one of them is already for GOMODCACHE.
+func withModuleCache(caches []*Cache) []*Cache {
+	for _, c := range caches {
+		if strings.Trim(c.Env, " \n") == "GOMODCACHE" {
+			return caches
+		}
+	}
+	return append([]*Cache{{Env: "GOMODCACHE", Key: GO_MODULE_CACHE_KEY}}, caches...)
+}
+
+//mountCaches adds the cache volumes to runConfig and points their environment
+//variables at them.  The variables are only for this container; an image committed
+//from it doesn't have the volumes, so commitConfig leaves them out.
+func mountCaches(runConfig *io.RunConfig, caches []*cacheMount) {
+	for _, m := range caches {
+		runConfig.Volumes[m.volume] = m.dir
+		if m.env != "" {
+			runConfig.Env = append(runConfig.Env, m.env+"="+m.dir)
+		}
+	}
+}
+
+//cacheUsers returns the caches of this configuration, by volume name, and the
+//builds and tests that use each of them.
+func (c *Config) cacheUsers() map[string][]string {
+	result := make(map[string][]string)
+	for name, n := range c.nameToNode {
+		var caches []*cacheMount
+		switch impl := n.implementation().(type) {
+		case *goBuilder:
+			caches = impl.caches
+		case *goTester:
+			caches = impl.caches
+		default:
+			continue
+		}
+		for _, m := range caches {
+			result[m.volume] = append(result[m.volume], name)
+		}
+	}
+	for _, users := range result {
+		sort.Strings(users)
+	}
+	return result
+}
+
+// CmdCache shows the build caches of the configuration, who uses them and whether they
+// exist yet.  If clear is true, the caches are removed instead.  If volumes are given,
+// only those caches are shown or cleared; the prefix pickett-cache- may be left off.
+func CmdCache(clear bool, volumes []string, config *Config) error {
+	users := config.cacheUsers()
+	chosen := []string{}
+	for _, v := range volumes {
+		if _, ok := users[v]; !ok {
+			v = CACHE_VOLUME_PREFIX + v
+		}
+		if _, ok := users[v]; !ok {
+			return fmt.Errorf("no build uses the cache %s", v)
+		}
+		chosen = append(chosen, v)
+	}
+	if len(chosen) == 0 {
+		for v := range users {
+			chosen = append(chosen, v)
+		}
+	}
+	sort.Strings(chosen)
+
+	existing, err := config.cli.ListVolumes()
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool)
+	for _, v := range existing {
+		exists[v.Name] = true
+	}
+
+	for _, v := range chosen {
+		if !clear {
+			state := "not created"
+			if exists[v] {
+				state = "exists"
+			}
+			fmt.Printf("%-31s | %-11s | %s\n", v, state, strings.Join(users[v], ", "))
+			continue
+		}
+		if !exists[v] {
+			continue
+		}
+		fmt.Printf("[pickett] clearing cache %s\n", v)
+		if err := config.cli.RemoveVolume(v); err != nil {
+			return fmt.Errorf("%s: %v", v, err)
+		}
+	}
+	return nil
+}
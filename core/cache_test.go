@@ -0,0 +1,78 @@
+package pickett
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+	"github.com/igneous-systems/pickett/io"
+)
+
+var cacheExample = strings.Replace(testExample, `"Packages": ["p1/...", "p2"]`,
+	`"Packages": ["p1/...", "p2"],
+			"Caches" : [
+				{ "Env" : "GOCACHE", "Key" : "Shared Go" },
+				{ "MountedAt" : "/root/.cache/golangci-lint" }
+			]`, 1)
+
+func TestCachesMountedAndCleared(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+
+	setupForLabels(helper)
+	setupForUserConfig(helper)
+	helper.EXPECT().OpenDockerfileRelative("godir").Return(nil, nil)
+	c, err := NewConfig(strings.NewReader(cacheExample), helper, cli, etcd)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	helper.EXPECT().DirectoryRelative("src").Return("/home/gredo/src").AnyTimes()
+
+	var runConfig *io.RunConfig
+	cli.EXPECT().CmdRun(gomock.Any(), "go", "test", "-json", "p1/...", "p2").Do(
+		func(rc *io.RunConfig, s ...string) { runConfig = rc }).Return(bytes.NewBufferString(testEvents), "testcont", nil)
+	cont := io.NewMockInspectedContainer(controller)
+	cont.EXPECT().ExitStatus().Return(1)
+	cli.EXPECT().InspectContainer("testcont").Return(cont, nil)
+	cli.EXPECT().CmdRmContainer("testcont").Return(nil)
+	helper.EXPECT().WriteFileRelative(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	etcd.EXPECT().Put("/pickett/tests/unit", gomock.Any()).Return("", nil)
+
+	tester, _ := c.tester("unit")
+	tester.build(c)
+
+	if runConfig.Volumes["pickett-cache-shared-go"] != "/pickett/caches/gocache" {
+		t.Errorf("shared cache not mounted: %v", runConfig.Volumes)
+	}
+	if runConfig.Volumes["pickett-cache-unit-golangci-lint"] != "/root/.cache/golangci-lint" {
+		t.Errorf("private cache not mounted: %v", runConfig.Volumes)
+	}
+	if !contains(runConfig.Env, "GOCACHE=/pickett/caches/gocache") {
+		t.Errorf("GOCACHE not set: %v", runConfig.Env)
+	}
+
+	//an image committed from a build doesn't point at volumes it doesn't have
+	runIn := io.NewMockInspectedImage(controller)
+	runIn.EXPECT().Env().Return([]string{"PATH=/usr/local/go/bin"})
+	runIn.EXPECT().WorkingDir().Return("/go")
+	cli.EXPECT().InspectImage(runConfig.Image).Return(runIn, nil)
+	commitConf, err := commitConfig(c, runConfig.Image, runConfig)
+	if err != nil || !contains(commitConf.Env, "GOCACHE=") || contains(commitConf.Env, "GOCACHE=/pickett/caches/gocache") {
+		t.Errorf("expected GOCACHE to be left out of the image: %v %v", commitConf, err)
+	}
+
+	//only the cache that exists is removed
+	cli.EXPECT().ListVolumes().Return([]*io.VolumeInfo{{Name: "pickett-cache-shared-go"}}, nil)
+	cli.EXPECT().RemoveVolume("pickett-cache-shared-go").Return(nil)
+	if err := CmdCache(true, nil, c); err != nil {
+		t.Errorf("unable to clear caches: %v", err)
+	}
+	if err := CmdCache(true, []string{"nonesuch"}, c); err == nil {
+		t.Errorf("expected unknown cache to be an error")
+	}
+}
@@ -0,0 +1,95 @@
+package pickett
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+//These are the legal values of the Mode of a CodeVolume.  BIND mounts the directory
+//from the docker host, so it must be visible there (possibly after path translation).
+//COPY keeps a copy of the directory in a docker volume on the daemon's side, which is
+//brought up to date before each use, so it works with a daemon on another machine.
+const (
+	CODE_VOLUME_BIND = "BIND"
+	CODE_VOLUME_COPY = "COPY"
+)
+
+//CODEVOLUMES is where the state of the last sync of each COPY code volume is kept.
+const CODEVOLUMES = "codevolumes"
+
+//CODE_VOLUME is the value of the io.LABEL_TYPE label on the containers used to
+//copy code volumes.
+const CODE_VOLUME = "codevolume"
+
+//checkCodeVolumes makes sure the code volumes are sensible and normalizes their modes.
+func (c *Config) checkCodeVolumes() error {
+	for _, v := range c.CodeVolumes {
+		v.Mode = strings.ToUpper(strings.Trim(v.Mode, " \n"))
+		switch v.Mode {
+		case "":
+			v.Mode = CODE_VOLUME_BIND
+		case CODE_VOLUME_BIND, CODE_VOLUME_COPY:
+		default:
+			return fmt.Errorf("unknown Mode %s for code volume %s, should be %s or %s",
+				v.Mode, v.Directory, CODE_VOLUME_BIND, CODE_VOLUME_COPY)
+		}
+	}
+	return nil
+}
+
+//codeVolumeName returns the name of the docker volume that holds the copy of the
+//directory dir, which is on this machine.
+func codeVolumeName(dir string) string {
+	hash := fmt.Sprintf("%x", sha1.Sum([]byte(filepath.Clean(dir))))
+	return "pickett-code-" + hash[:12]
+}
+
+//syncCodeVolume brings the docker volume for dir up to date, using a container
+//made from image to get at it, and returns the name of the volume.  The time of
+//the last sync and the manifest of the files copied are kept in etcd, so only the
+//files changed since then have to be copied.
+func (c *Config) syncCodeVolume(dir string, image string) (string, error) {
+	name := codeVolumeName(dir)
+	key := filepath.Join(io.PICKETT_KEYSPACE, CODEVOLUMES, name)
+	sync := &io.VolumeSync{
+		Volume:    name,
+		SourceDir: dir,
+		Image:     image,
+		Labels:    c.labels(name, CODE_VOLUME, "", 0, ""),
+	}
+	value, found, err := c.etcd.Get(key)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		parts := strings.Fields(value)
+		if len(parts) == 2 {
+			nanos, err := strconv.ParseInt(parts[0], 10, 64)
+			if err == nil {
+				sync.Since = time.Unix(0, nanos)
+				sync.Manifest = parts[1]
+			}
+		}
+	}
+
+	//take the time before we look at the files, anything changed while we copy
+	//will be picked up next time
+	now := time.Now()
+	manifest, count, err := c.cli.CmdSyncVolume(sync)
+	if err != nil {
+		return "", fmt.Errorf("unable to copy %s to volume %s: %v", dir, name, err)
+	}
+	if count != 0 {
+		fmt.Printf("[pickett] copied %d files from %s to volume %s\n", count, dir, name)
+	}
+	if _, err := c.etcd.Put(key, fmt.Sprintf("%d %s", now.UnixNano(), manifest)); err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,71 @@
+package pickett
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/gomock/gomock"
+	"github.com/igneous-systems/pickett/io"
+)
+
+func TestCopyCodeVolumeSyncsChanges(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+
+	setupForExample1Conf(controller, helper)
+	setupForLabels(helper)
+	helper.EXPECT().DirectoryRelative("src").Return("/home/gredo/project/src").AnyTimes()
+
+	copyExample := strings.Replace(example1, `"MountedAt" : "/han",`, `"MountedAt" : "/han", "Mode" : "copy",`, 1)
+	c, err := NewConfig(strings.NewReader(copyExample), helper, cli, etcd)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	if c.CodeVolumes[0].Mode != CODE_VOLUME_COPY {
+		t.Fatalf("failed to parse CodeVolume>Mode: %s", c.CodeVolumes[0].Mode)
+	}
+	name := codeVolumeName("/home/gredo/project/src")
+	key := "/pickett/codevolumes/" + name
+
+	//first time, nothing is known so everything must be copied
+	var syncs []*io.VolumeSync
+	capture := func(s *io.VolumeSync) { syncs = append(syncs, s) }
+	etcd.EXPECT().Get(key).Return("", false, nil)
+	cli.EXPECT().CmdSyncVolume(gomock.Any()).Do(capture).Return("abc123", 3, nil)
+	etcd.EXPECT().Put(key, gomock.Any()).Return("", nil)
+
+	vols, err := c.codeVolumes("blah:bletch")
+	if err != nil {
+		t.Fatalf("unable to compute code volumes: %v", err)
+	}
+	if vols[name] != "/han" {
+		t.Errorf("expected volume %s to be mounted at /han: %v", name, vols)
+	}
+
+	//second time, only changes since the last sync are needed
+	etcd.EXPECT().Get(key).Return("1000 abc123", true, nil)
+	cli.EXPECT().CmdSyncVolume(gomock.Any()).Do(capture).Return("abc123", 0, nil)
+	etcd.EXPECT().Put(key, gomock.Any()).Return("", nil)
+
+	if _, err := c.codeVolumes("blah:bletch"); err != nil {
+		t.Fatalf("unable to compute code volumes: %v", err)
+	}
+
+	if len(syncs) != 2 {
+		t.Fatalf("expected two syncs but got %d", len(syncs))
+	}
+	if syncs[0].SourceDir != "/home/gredo/project/src" || syncs[0].Image != "blah:bletch" || syncs[0].Volume != name {
+		t.Errorf("bad sync request: %+v", syncs[0])
+	}
+	if !syncs[0].Since.IsZero() || syncs[0].Manifest != "" {
+		t.Errorf("expected first sync to copy everything: %+v", syncs[0])
+	}
+	if !syncs[1].Since.Equal(time.Unix(0, 1000)) || syncs[1].Manifest != "abc123" {
+		t.Errorf("expected second sync to be incremental: %+v", syncs[1])
+	}
+}
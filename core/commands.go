@@ -26,7 +26,15 @@ func CmdRun(target string, runVol string, config *Config) (int, error) {
 		if len(pair) != 2 {
 			return 1, fmt.Errorf("unable to understand run volume (%s), should be /foo:/bar/foo", runVol)
 		}
-		vol = &runVolumeSpec{pair[0], pair[1]}
+		source, err := filepath.Abs(pair[0])
+		if err != nil {
+			return 1, err
+		}
+		source, err = config.translate(source)
+		if err != nil {
+			return 1, err
+		}
+		vol = &runVolumeSpec{source, pair[1]}
 	}
 	return config.Execute(target, vol)
 }
@@ -154,6 +162,18 @@ func CmdStatus(targets []string, config *Config) error {
 		}
 	}
 	for _, target := range buildStatus {
+		if tester, ok := config.tester(target); ok {
+			t, result, err := tester.lastResult(config)
+			if err != nil {
+				return err
+			}
+			if t.IsZero() {
+				fmt.Printf("%-25s | %-31s\n", target, "not run")
+			} else {
+				fmt.Printf("%-25s | %-31s | %-19s\n", target, result, t.Format(TIME_FORMAT))
+			}
+			continue
+		}
 		insp, err := config.cli.InspectImage(target)
 		if err != nil && err.Error() != "no such image" {
 			return err
@@ -194,9 +214,18 @@ func CmdStatus(targets []string, config *Config) error {
 	return nil
 }
 
-// CmdStop stops the targets containers
+// CmdStop stops the targets containers, apart from those that running entries of other
+// topologies still consume.
 func CmdStop(targets []string, config *Config) error {
-	stopSet := chosenRunnables(config, targets)
+	stopSet, err := spareConsumed(config, chosenRunnables(config, targets))
+	if err != nil {
+		return err
+	}
+	return stopRunnables(stopSet, config)
+}
+
+//stopRunnables stops the containers of the topology entries in stopSet.
+func stopRunnables(stopSet []string, config *Config) error {
 	for _, stop := range stopSet {
 		pair := strings.Split(stop, ".")
 		if len(pair) != 2 {
@@ -223,13 +252,86 @@ func CmdStop(targets []string, config *Config) error {
 	return nil
 }
 
-// CmdDrop stops and removes the targets containers
+//spareConsumed returns the topology entries in stopping that can be stopped.  An entry
+//that a running entry of another topology consumes is shared with that topology, so it
+//is left running, and so is what it consumes itself.
+func spareConsumed(config *Config, stopping []string) ([]string, error) {
+	running := make(map[string]bool)
+	isRunning := func(topo string, name string) (bool, error) {
+		target := topo + "." + name
+		if up, ok := running[target]; ok {
+			return up, nil
+		}
+		instances, err := statusInstances(topo, name, config)
+		if err != nil {
+			return false, err
+		}
+		running[target] = false
+		for _, contId := range instances {
+			if contId == "" {
+				continue
+			}
+			insp, err := config.cli.InspectContainer(contId)
+			if err == nil && insp.Running() {
+				running[target] = true
+				break
+			}
+		}
+		return running[target], nil
+	}
+
+	spared := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for topo, entries := range config.nameToTopology {
+			for name, info := range entries {
+				r, ok := info.runner.(*topoRunner)
+				if !ok {
+					continue
+				}
+				consumer := topo + "." + name
+				for _, other := range r.consumes {
+					consumed := other.topology() + "." + other.name()
+					if spared[consumed] || !contains(stopping, consumed) {
+						continue
+					}
+					keep := spared[consumer]
+					if !keep && other.topology() != topo && !contains(stopping, consumer) {
+						up, err := isRunning(topo, name)
+						if err != nil {
+							return nil, err
+						}
+						keep = up
+					}
+					if keep {
+						fmt.Printf("[pickett] leaving %s running, %s still consumes it\n", consumed, consumer)
+						spared[consumed] = true
+						changed = true
+					}
+				}
+			}
+		}
+	}
+
+	result := []string{}
+	for _, target := range stopping {
+		if !spared[target] {
+			result = append(result, target)
+		}
+	}
+	return result, nil
+}
+
+// CmdDrop stops and removes the targets containers, apart from those that running entries
+// of other topologies still consume.
 func CmdDrop(targets []string, config *Config) error {
-	err := CmdStop(targets, config)
+	dropSet, err := spareConsumed(config, chosenRunnables(config, targets))
 	if err != nil {
 		return err
 	}
-	dropSet := chosenRunnables(config, targets)
+	if err := stopRunnables(dropSet, config); err != nil {
+		return err
+	}
 	for _, drop := range dropSet {
 		pair := strings.Split(drop, ".")
 		if len(pair) != 2 {
@@ -274,6 +376,13 @@ func CmdWipe(targets []string, config *Config) error {
 		}
 	}
 	for _, image := range toWipe {
+		//tests have no image, forgetting the result makes them run again
+		if tester, ok := config.tester(image); ok {
+			if err := tester.forget(config); err != nil {
+				return fmt.Errorf("%s: %v", image, err)
+			}
+			continue
+		}
 		err := config.cli.CmdRmImage(image)
 		if err != nil {
 			if err.Error() == "no such image" {
@@ -1,11 +1,10 @@
 package pickett
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"path/filepath"
 	"strings"
 
 	pickett_io "github.com/igneous-systems/pickett/io"
@@ -16,11 +15,14 @@ type Container struct {
 	Tag        string
 	Directory  string
 	DependsOn  []string
+	BuildArgs  map[string]string
+	Template   bool
 }
 
 type CodeVolume struct {
 	Directory string
 	MountedAt string
+	Mode      string
 }
 
 type GoBuild struct {
@@ -31,6 +33,45 @@ type GoBuild struct {
 	Packages   []string
 	TestFile   string
 	Probe      string
+	ModuleDir  string
+	Outputs    map[string]string
+	Platforms  []string
+	Parallel   bool
+	Caches     []*Cache
+	Image      *ImageMetadata
+}
+
+// ImageMetadata is how an image pickett makes is to be run, like ENTRYPOINT, CMD, ENV,
+// WORKDIR, USER, EXPOSE and LABEL in a Dockerfile.  ExposedPorts are like "8080" or
+// "53/udp".
+type ImageMetadata struct {
+	Entrypoint   []string
+	Cmd          []string
+	Env          map[string]string
+	WorkingDir   string
+	User         string
+	ExposedPorts []string
+	Labels       map[string]string
+}
+
+// Cache is a docker volume mounted at MountedAt in the containers of a go build or
+// test, so what the go tools keep there survives from one run to the next.  If Env is
+// given, that environment variable points at it.  Builds with the same Key share it.
+type Cache struct {
+	Key       string
+	MountedAt string
+	Env       string
+}
+
+type GoTest struct {
+	Name      string
+	RunIn     string
+	Packages  []string
+	ModuleDir string
+	Command   string
+	Format    string
+	ReportDir string
+	Caches    []*Cache
 }
 
 type GenericBuild struct {
@@ -40,8 +81,13 @@ type GenericBuild struct {
 }
 
 type Artifact struct {
-	BuiltPath      string
-	DestinationDir string
+	BuiltPath       string
+	DestinationDir  string
+	DestinationName string
+	Exclude         []string
+	Flatten         bool
+	Mode            string
+	Owner           string
 }
 
 type Extraction struct {
@@ -50,6 +96,7 @@ type Extraction struct {
 	MergeWith  string
 	Tag        string
 	Artifacts  []*Artifact
+	Image      *ImageMetadata
 }
 
 type TopologyEntry struct {
@@ -63,6 +110,8 @@ type TopologyEntry struct {
 	Devices    map[string]string
 	Privileged bool
 	WaitFor    bool
+	Optional   bool
+	Profiles   map[string]*EntryProfile
 }
 
 type BuildOpts struct {
@@ -77,9 +126,15 @@ type topoInfo struct {
 
 type Config struct {
 	DockerBuildOptions BuildOpts
+	Endpoints          *pickett_io.Endpoints
+	Variables          map[string]string
+	Include            []string
+	PathTranslation    string
+	PathMappings       []*PathMapping
 	CodeVolumes        []*CodeVolume
 	Containers         []*Container
 	GoBuilds           []*GoBuild
+	GoTests            []*GoTest
 	Extractions        []*Extraction
 	GenericBuilds      []*GenericBuild
 	Topologies         map[string][]*TopologyEntry
@@ -90,6 +145,14 @@ type Config struct {
 	helper         pickett_io.Helper
 	cli            pickett_io.DockerCli
 	etcd           pickett_io.EtcdClient
+	prov           *provenance
+	paths          *pathTranslator
+	warnings       []string
+	variables      *variables
+	profile        string
+	activeEntries  map[string][]*TopologyEntry
+	images         map[string]bool
+	switchedOff    map[string]bool
 }
 
 type topoMap map[string]*topoInfo
@@ -98,29 +161,65 @@ type topoMap map[string]*topoInfo
 // all the parsing of the config file and validation checking on the
 // items therein.
 func NewConfig(reader io.Reader, helper pickett_io.Helper, cli pickett_io.DockerCli, etcd pickett_io.EtcdClient) (*Config, error) {
+	return NewConfigWithOptions(reader, helper, cli, etcd, nil)
+}
+
+// Connect gives the configuration the docker and etcd it works with.  Reading the
+// configuration needs neither, so they can be given once it is known they are needed.
+func (c *Config) Connect(cli pickett_io.DockerCli, etcd pickett_io.EtcdClient) {
+	c.cli = cli
+	c.etcd = etcd
+}
+
+// NewConfigWithOptions is NewConfig with control over how the file is read, such as
+// the values of variables.  The options may be nil.
+func NewConfigWithOptions(reader io.Reader, helper pickett_io.Helper, cli pickett_io.DockerCli, etcd pickett_io.EtcdClient, options *ConfigOptions) (*Config, error) {
 	all, err := ioutil.ReadAll(reader)
 	if err != nil {
 		return nil, fmt.Errorf("could not read all of configuration file: %v", err)
 	}
-	lines := strings.Split(string(all), "\n")
-	var noComments bytes.Buffer
-	for _, line := range lines {
-		if index := strings.Index(line, "//"); index != -1 {
-			if index == 0 {
-				continue
+
+	//try to decode the configuration, whatever its format, once the references
+	//to variables have been replaced
+	doc, file, err := parseConfig(readerName(reader, ""), all)
+	if err != nil {
+		return nil, err
+	}
+	from := make(sources)
+	included, err := includeConfigs(helper, file, doc, from)
+	if err != nil {
+		return nil, err
+	}
+	if options != nil {
+		for _, overlay := range options.Overlays {
+			if err := applyOverlay(overlay, file, doc, from); err != nil {
+				return nil, err
 			}
-			line = line[:index]
 		}
-		noComments.WriteString(line)
 	}
-
-	//try to decode the json blob
-	dec := json.NewDecoder(&noComments)
-	conf := &Config{}
-	err = dec.Decode(&conf)
+	vars, err := newVariables(file, doc, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := vars.interpolateConfig(file, doc, from); err != nil {
+		return nil, err
+	}
+	conf := &Config{variables: vars}
+	if options != nil {
+		conf.profile = strings.TrimSpace(options.Profile)
+	}
+	warnings, err := decodeDocument(file, doc, from, conf)
 	if err != nil {
 		return nil, err
 	}
+	if err := conf.settleIncluded(file, doc, from); err != nil {
+		return nil, err
+	}
+	warnings = append(included, warnings...)
+	for _, w := range warnings {
+		flog.Warningf("%s", w)
+	}
+	conf.warnings = warnings
 
 	//save the objects and put them where the true configuration parsing
 	//can see them
@@ -133,6 +232,15 @@ func NewConfig(reader io.Reader, helper pickett_io.Helper, cli pickett_io.Docker
 	conf.nameToNode = make(map[string]node)
 	conf.nameToTopology = make(map[string]topoMap)
 
+	//the user's own config may override these, but these need to make sense
+	if _, err := newPathTranslator(conf.PathTranslation, conf.PathMappings); err != nil {
+		return nil, err
+	}
+
+	if err := conf.checkCodeVolumes(); err != nil {
+		return nil, err
+	}
+
 	// PART 1: containers cannot reference anything other than containers,
 	// PART 1: so we can just process them
 	if err := conf.checkContainerNodes(); err != nil {
@@ -147,6 +255,9 @@ func NewConfig(reader io.Reader, helper pickett_io.Helper, cli pickett_io.Docker
 	if err != nil {
 		return nil, err
 	}
+	if err := conf.checkProfile(); err != nil {
+		return nil, err
+	}
 	topos := make(map[string]map[*topoRunner]string)
 	for top, entries := range conf.Topologies {
 		t := strings.Trim(top, " \n")
@@ -157,10 +268,17 @@ func NewConfig(reader io.Reader, helper pickett_io.Helper, cli pickett_io.Docker
 		}
 		topos[t] = impl
 	}
+	if err := conf.consumesTopologyNodes(); err != nil {
+		return nil, err
+	}
 	extractImpl, err := conf.checkExtractionNodes()
 	if err != nil {
 		return nil, err
 	}
+	testImpl, err := conf.checkGoTestNodes()
+	if err != nil {
+		return nil, err
+	}
 
 	//PART 3: We now have the full set of possible nodes, so we want to
 	//PART 3: introduce edges between them.
@@ -175,10 +293,33 @@ func NewConfig(reader io.Reader, helper pickett_io.Helper, cli pickett_io.Docker
 	if err := conf.dependenciesExtractNodes(extractImpl); err != nil {
 		return nil, err
 	}
+	if err := conf.dependenciesGoTestNodes(testImpl); err != nil {
+		return nil, err
+	}
 
 	return conf, nil
 }
 
+// ReadEndpoints returns the endpoints given in a configuration file, without doing any
+// of the other parsing or checking.  This is needed to connect to docker and etcd
+// before the configuration can be fully understood.  If there are none, the result is
+// empty but not nil.
+func ReadEndpoints(reader io.Reader) (*pickett_io.Endpoints, error) {
+	all, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not read all of configuration file: %v", err)
+	}
+	//unknown keys are warned about when the whole file is read
+	conf := &Config{}
+	if _, err := decodeConfig(readerName(reader, ""), all, conf); err != nil {
+		return nil, err
+	}
+	if conf.Endpoints == nil {
+		return &pickett_io.Endpoints{}, nil
+	}
+	return conf.Endpoints, nil
+}
+
 // EntryPoints returns two lists, the list of buildable targets and the list of runnable
 // topologies.
 func (c *Config) EntryPoints() ([]string, []string) {
@@ -253,19 +394,52 @@ func (c *Config) Execute(name string, vol *runVolumeSpec) (int, error) {
 	return exitStatus, nil
 }
 
-// codeVolumes returns a map from container-external to container-internal paths.
-func (c *Config) codeVolumes() (map[string]string, error) {
+// hostCodeVolumes returns a map from paths on this machine to container-internal paths.
+func (c *Config) hostCodeVolumes() map[string]string {
+	results := make(map[string]string)
+	for _, v := range c.CodeVolumes {
+		results[c.helper.DirectoryRelative(v.Directory)] = v.MountedAt
+	}
+	return results
+}
+
+// containerPath returns the path inside a build container of dir, which is on this
+// machine and must be inside one of the code volumes.
+func (c *Config) containerPath(dir string) (string, error) {
+	clean := filepath.Clean(dir)
+	for _, v := range c.CodeVolumes {
+		root := c.helper.DirectoryRelative(v.Directory)
+		if clean == root {
+			return v.MountedAt, nil
+		}
+		if strings.HasPrefix(clean, strings.TrimRight(root, "/")+"/") {
+			return filepath.Join(v.MountedAt, clean[len(root):]), nil
+		}
+	}
+	return "", fmt.Errorf("%s is not inside any of the CodeVolumes", dir)
+}
+
+// codeVolumes returns a map from container-external to container-internal paths.  The
+// container-external paths are translated to be the paths the docker daemon sees.  Code
+// volumes in COPY mode are brought up to date first, using a container made from image,
+// and appear as the name of the docker volume holding the copy.
+func (c *Config) codeVolumes(image string) (map[string]string, error) {
 	results := make(map[string]string)
 	for _, v := range c.CodeVolumes {
 		dir := c.helper.DirectoryRelative(v.Directory)
-		var err error
-		if needsPathTranslation() {
-			dir, err = translatePath(dir)
+		if v.Mode == CODE_VOLUME_COPY {
+			name, err := c.syncCodeVolume(dir, image)
 			if err != nil {
 				return nil, err
 			}
+			results[name] = v.MountedAt
+			continue
+		}
+		translated, err := c.translate(dir)
+		if err != nil {
+			return nil, err
 		}
-		results[dir] = v.MountedAt
+		results[translated] = v.MountedAt
 	}
 	return results, nil
 }
@@ -1,6 +1,10 @@
 package pickett
 
 import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -52,6 +56,17 @@ func setupForExample1Conf(controller *gomock.Controller, helper *io.MockHelper)
 	helper.EXPECT().OpenDockerfileRelative("mydir").Return(nil, nil)
 }
 
+//setupForLabels allows the calls made to compute the labels on the things we create.
+func setupForLabels(helper *io.MockHelper) {
+	helper.EXPECT().ConfigFile().Return("/foo/bar/baz/Pickett.json").AnyTimes()
+	helper.EXPECT().SourceRevision().Return("0123456789abcdef").AnyTimes()
+}
+
+//setupForUserConfig acts as though the user has no ~/.pickett.json.
+func setupForUserConfig(helper *io.MockHelper) {
+	helper.EXPECT().UserConfigReader().Return(nil, nil).AnyTimes()
+}
+
 func TestConf(t *testing.T) {
 	controller := gomock.NewController(t)
 	defer controller.Finish()
@@ -70,3 +85,240 @@ func TestConf(t *testing.T) {
 		t.Errorf("failed to parse CodeVolume>Directory")
 	}
 }
+
+func TestConfWithoutDaemon(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	helper.EXPECT().OpenDockerfileRelative("somedir").Return(nil, nil)
+	setupForUserConfig(helper)
+
+	//images that pickett doesn't build are not looked for while reading
+	c, err := NewConfig(strings.NewReader(netExample), helper, nil, nil)
+	if err != nil {
+		t.Fatalf("can't read config without docker: %v", err)
+	}
+
+	cli := io.NewMockDockerCli(controller)
+	c.Connect(cli, nil)
+	cli.EXPECT().InspectImage("part3-image").Return(nil, fmt.Errorf("no such image"))
+	for i := 0; i < 2; i++ {
+		if c.imageExists("part3-image") {
+			t.Errorf("expected part3-image to be missing")
+		}
+	}
+	if !c.imageExists("netexample:part1") {
+		t.Errorf("expected images pickett builds to exist without asking docker")
+	}
+}
+
+func TestReadEndpoints(t *testing.T) {
+	ep, err := ReadEndpoints(strings.NewReader(`
+	{
+		// our secured build host
+		"Endpoints" : {
+			"DockerHost" : "tcp://build1.example.com",
+			"TLSVerify" : true,
+			"StateStore" : "etcd1.example.com,http://etcd2.example.com:2379"
+		},
+		"Containers" : [ { "Repository": "blah", "Tag" : "bletch", "Directory" : "mydir" } ]
+	}`))
+	if err != nil {
+		t.Fatalf("can't read endpoints: %v", err)
+	}
+	if ep.DockerHost != "tcp://build1.example.com" || ep.TLSVerify == nil || !*ep.TLSVerify {
+		t.Errorf("failed to parse Endpoints: %+v", ep)
+	}
+	ep, err = ReadEndpoints(strings.NewReader(example1))
+	if err != nil || ep == nil || ep.DockerHost != "" {
+		t.Errorf("expected empty endpoints without an Endpoints section: %+v, %v", ep, err)
+	}
+}
+
+func TestTLSVerifyPrecedence(t *testing.T) {
+	oldVerify := os.Getenv(io.ENV_TLS_VERIFY)
+	os.Setenv(io.ENV_TLS_VERIFY, "")
+	defer os.Setenv(io.ENV_TLS_VERIFY, oldVerify)
+
+	yes, no := true, false
+	file := &io.Endpoints{TLSVerify: &yes}
+	ep, err := io.ResolveEndpoints(nil, file)
+	if err != nil || !*ep.TLSVerify {
+		t.Errorf("expected TLSVerify from the file, got %+v (%v)", ep, err)
+	}
+	ep, err = io.ResolveEndpoints(&io.Endpoints{TLSVerify: &no}, file)
+	if err != nil || *ep.TLSVerify {
+		t.Errorf("expected the flag to turn off TLSVerify from the file, got %+v (%v)", ep, err)
+	}
+}
+
+func TestConfUnknownKeysWarned(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	helper.EXPECT().OpenDockerfileRelative("mydir").Return(nil, nil)
+
+	c, err := NewConfig(strings.NewReader(example1), helper, cli, nil)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	if len(c.warnings) != 1 || c.warnings[0] != "Pickett.json:12:4: unknown key CodeVolumes[0].SomeExtra is ignored" {
+		t.Errorf("expected a warning about SomeExtra, got %v", c.warnings)
+	}
+}
+
+func TestJSONCCommentsAndCommas(t *testing.T) {
+	content := []byte(`/* header,
+   with "quotes" */
+{
+	"Variables" : { "url" : "http://example.com/a//b", }, /* trailing */
+	"Containers" : [ ],
+}`)
+	conf := &Config{}
+	if _, err := decodeJSONC("x.json", content, conf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conf.Variables["url"] != "http://example.com/a//b" {
+		t.Errorf("string with // was damaged: %s", conf.Variables["url"])
+	}
+
+	_, err := decodeJSONC("x.json", []byte("{\n\t\"Variables\" : { \"a\" : 1 }\n}"), conf)
+	if err == nil || !strings.HasPrefix(err.Error(), "x.json:2:") {
+		t.Errorf("expected an error on line 2, got %v", err)
+	}
+	_, err = decodeJSONC("x.json", []byte("{\n /* oops\n}"), conf)
+	if err == nil || err.Error() != "x.json:2:2: comment is not terminated" {
+		t.Errorf("expected an unterminated comment, got %v", err)
+	}
+}
+
+var example1YAML = `
+# example1
+DockerBuildOptions: {}
+CodeVolumes:
+  - Directory: src
+    MountedAt: /han
+    SomeExtra: cruft
+Containers:
+  - &blah
+    Repository: blah
+    Tag: bletch
+    Directory: mydir
+GoBuilds:
+  - Repository: test
+    RunIn: "blah:bletch"
+    Packages: [p1..., p2/p3]
+    Command: go test
+    Tag: nashville
+  - Repository: fart
+    RunIn: "blah:bletch"
+    Packages: [p4..., p5/p6]
+    Tag: chattanooga
+`
+
+var example1TOML = `
+# example1
+[DockerBuildOptions]
+
+[[CodeVolumes]]
+Directory = "src"
+MountedAt = "/han"
+SomeExtra = "cruft"
+
+[[Containers]]
+Repository = "blah"
+Tag = "bletch"
+Directory = "mydir"
+
+[[GoBuilds]]
+Repository = "test"
+RunIn = "blah:bletch"
+Packages = ["p1...", "p2/p3"]
+Command = "go test"
+Tag = "nashville"
+
+[[GoBuilds]]
+Repository = "fart"
+RunIn = "blah:bletch"
+Packages = ["p4...", "p5/p6"]
+Tag = "chattanooga"
+`
+
+func TestConfYAMLAndTOML(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	helper.EXPECT().OpenDockerfileRelative("mydir").Return(nil, nil).Times(2)
+
+	//neither has a name, so the format is found by looking
+	expected := map[string]string{
+		example1YAML: "Pickett.yaml:7:5: unknown key CodeVolumes[0].SomeExtra is ignored",
+		example1TOML: "Pickett.toml:8:1: unknown key CodeVolumes[0].SomeExtra is ignored",
+	}
+	for example, warning := range expected {
+		c, err := NewConfig(strings.NewReader(example), helper, cli, nil)
+		if err != nil {
+			t.Fatalf("can't parse legal config file: %v", err)
+		}
+		if c.CodeVolumes[0].MountedAt != "/han" || len(c.GoBuilds) != 2 || c.GoBuilds[1].Packages[1] != "p5/p6" {
+			t.Errorf("failed to parse %s", warning)
+		}
+		if _, ok := c.nameToNode["test:nashville"]; !ok {
+			t.Errorf("no node for the go build: %v", c.nameToNode)
+		}
+		if len(c.warnings) != 1 || c.warnings[0] != warning {
+			t.Errorf("expected %q, got %v", warning, c.warnings)
+		}
+	}
+
+	//mistakes are reported where they are in the file; x.cfg is sniffed as TOML
+	mistakes := map[string]string{
+		"x.yaml": "Containers:\n  - Repository: blah\n    Tag: [1, 2]\n",
+		"x.yml":  "Containers:\n  - Repository: blah\n    Tag: bletch: 2\n",
+		"x.toml": "[[Containers]]\nRepository = \"blah\"\nTag = [1, 2]\n",
+		"x.cfg":  "[[Containers]]\nRepository = \"blah\"\nTag = = 2\n",
+	}
+	for file, content := range mistakes {
+		_, err := decodeConfig(file, []byte(content), &Config{})
+		if err == nil || !strings.HasPrefix(err.Error(), file+":3") {
+			t.Errorf("expected an error on line 3 of %s, got %v", file, err)
+		}
+	}
+}
+
+func TestConvertConfig(t *testing.T) {
+	original := &Config{}
+	if _, err := decodeConfig("", []byte(example1), original); err != nil {
+		t.Fatalf("can't decode example: %v", err)
+	}
+	//round trip through each format, ending up back at JSON
+	content := []byte(example1)
+	for _, to := range []string{FORMAT_YAML, FORMAT_TOML, FORMAT_JSON} {
+		var err error
+		content, err = ConvertConfig(bytes.NewReader(content), to)
+		if err != nil {
+			t.Fatalf("can't convert to %s: %v", to, err)
+		}
+		if sniffFormat(content) != to {
+			t.Errorf("converting to %s made something else:\n%s", to, content)
+		}
+		converted := &Config{}
+		if _, err := decodeConfig("", content, converted); err != nil {
+			t.Fatalf("can't decode result of converting to %s: %v\n%s", to, err, content)
+		}
+		if !reflect.DeepEqual(original, converted) {
+			t.Errorf("converting to %s changed the configuration:\n%s", to, content)
+		}
+		if to != FORMAT_JSON && !strings.Contains(string(content), "# a comment\n") {
+			t.Errorf("converting to %s lost the comments:\n%s", to, content)
+		}
+	}
+	if _, err := ConvertConfig(strings.NewReader(example1), "xml"); err == nil {
+		t.Errorf("expected unknown format to be an error")
+	}
+}
@@ -2,6 +2,9 @@ package pickett
 
 import (
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	pickett_io "github.com/igneous-systems/pickett/io"
@@ -31,6 +34,10 @@ func (c *Config) checkExistingTopologyName(topoName, nodeName string) error {
 	if _, ok := tmap[n]; ok {
 		return fmt.Errorf("node named %s already in use in topology %s", n, t)
 	}
+	//other topologies consume it as topology.node
+	if strings.Contains(n, ".") {
+		return fmt.Errorf("node named %s can't have a . in it (in topology %s)", n, t)
+	}
 	return nil
 
 }
@@ -49,11 +56,22 @@ func (c *Config) checkContainerNodes() error {
 		node := newNodeImpl(w)
 		c.nameToNode[w.tag()] = node
 	}
-	//make a pass adding edges
+	//make a pass adding edges, both the declared ones and the ones implied by
+	//references to other containers
 	for _, img := range c.Containers {
 		dest := c.nameToNode[img.Repository+":"+img.Tag]
 		work := dest.implementation().(*containerBuilder)
-		for _, source := range img.DependsOn {
+		if err := work.resolve(c); err != nil {
+			return fmt.Errorf("image %s: %v (containers can only refer to other containers)", work.tag(), err)
+		}
+		warnDependsOn(work.tag(), img.DependsOn, work.inferred)
+		sources := []string{}
+		for _, source := range append(append(append([]string{}, img.DependsOn...), work.refs...), work.inferred...) {
+			if !contains(sources, source) {
+				sources = append(sources, source)
+			}
+		}
+		for _, source := range sources {
 			node_source, ok := c.nameToNode[source]
 			if !ok {
 				return fmt.Errorf("image %s depends on %s, but %s not found",
@@ -66,22 +84,50 @@ func (c *Config) checkContainerNodes() error {
 	return nil
 }
 
+//warnDependsOn warns about the differences between the DependsOn of an image and the
+//containers its Dockerfile refers to.  Without a DependsOn, there is nothing to compare.
+func warnDependsOn(image string, declared []string, inferred []string) {
+	if len(declared) == 0 {
+		if len(inferred) != 0 {
+			flog.Debugf("image %s depends on %v according to its Dockerfile", image, inferred)
+		}
+		return
+	}
+	for _, d := range declared {
+		if !contains(inferred, strings.Trim(d, " \n")) {
+			flog.Warningf("image %s has %s in DependsOn, but its Dockerfile does not use it", image, d)
+		}
+	}
+	for _, i := range inferred {
+		if !contains(declared, i) {
+			flog.Warningf("image %s is built from %s, which is missing from its DependsOn", image, i)
+		}
+	}
+}
+
 // checkGoBuildNodes verifies all the "go build" nodes in this pickett file.  Note that
 // this should not be called until after the checkSourceNodes() have been
 // extracted as it needs data structures built at that stage.
 func (c *Config) checkGoBuildNodes() (map[*goBuilder]string, error) {
 	implementations := make(map[*goBuilder]string)
 	for _, build := range c.GoBuilds {
-		w, err := c.newGoBuilder(build)
-		if err != nil {
-			return nil, err
+		//without platforms, there is one node built for whatever RunIn runs
+		platforms := build.Platforms
+		if len(platforms) == 0 {
+			platforms = []string{""}
 		}
-		if err := c.checkExistingNodeName(build.Tag); err != nil {
-			return nil, err
+		for _, platform := range platforms {
+			w, err := c.newGoBuilder(build, platform)
+			if err != nil {
+				return nil, err
+			}
+			if err := c.checkExistingNodeName(w.tag()); err != nil {
+				return nil, err
+			}
+			node := newNodeImpl(w)
+			c.nameToNode[w.tag()] = node
+			implementations[w] = strings.Trim(build.RunIn, " \n")
 		}
-		node := newNodeImpl(w)
-		c.nameToNode[w.tag()] = node
-		implementations[w] = strings.Trim(build.RunIn, " \n")
 	}
 	return implementations, nil
 }
@@ -103,14 +149,22 @@ func (c *Config) dependenciesGoBuildNodes(implementations map[*goBuilder]string)
 	return nil
 }
 
-//check to see if a given image exists, it could be something we are going to construct
-//it might just be in the docker cache or the docker repo
-func (c *Config) tagExists(tag string, cli pickett_io.DockerCli) bool {
-	_, ok := c.nameToNode[strings.Trim(tag, " \n")]
-	if ok {
+//imageExists checks that an image is one we are going to construct, or is in the docker
+//cache.  This needs docker, so it is only done when the image is about to be used, not
+//when the configuration is read; docker is only asked once about each image.
+func (c *Config) imageExists(tag string) bool {
+	tag = strings.Trim(tag, " \n")
+	if _, ok := c.nameToNode[tag]; ok {
 		return true
 	}
-	_, err := cli.InspectImage(strings.Trim(tag, " \n"))
+	if exists, ok := c.images[tag]; ok {
+		return exists
+	}
+	_, err := c.cli.InspectImage(tag)
+	if c.images == nil {
+		c.images = make(map[string]bool)
+	}
+	c.images[tag] = err == nil
 	return err == nil
 }
 
@@ -150,10 +204,6 @@ func (c *Config) dependenciesExtractNodes(implementations map[*extractionBuilder
 		in, merge := cand[0], cand[1]
 
 		//incoming from runIn
-		if !c.tagExists(in, c.cli) {
-			return fmt.Errorf("Unable to find '%s' (RunIn) in extract build  '%s': maybe you need to 'docker pull' it?",
-				in, extract.tag())
-		}
 		r, found := c.nameToNode[in]
 		n := nodeOrName{name: in}
 		if found {
@@ -163,10 +213,6 @@ func (c *Config) dependenciesExtractNodes(implementations map[*extractionBuilder
 		extract.runIn = n
 
 		//incoming from mergeWith
-		if !c.tagExists(merge, c.cli) {
-			return fmt.Errorf("Unable to find '%s' (MergeWith) in extract build '%s': maybe you need to 'docker pull' it?",
-				merge, extract.tag())
-		}
 		m, found := c.nameToNode[merge]
 		n = nodeOrName{name: merge}
 		if found {
@@ -187,6 +233,38 @@ func (c *Config) dependenciesExtractNodes(implementations map[*extractionBuilder
 	return nil
 }
 
+// checkGoTestNodes verifies the simple portion of the go test nodes.  This does not
+// introduce edges as that requires that all the nodes be known.
+func (c *Config) checkGoTestNodes() (map[*goTester]string, error) {
+	implementations := make(map[*goTester]string)
+	for _, test := range c.GoTests {
+		w, err := c.newGoTester(test)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.checkExistingNodeName(w.tag()); err != nil {
+			return nil, err
+		}
+		node := newNodeImpl(w)
+		c.nameToNode[w.tag()] = node
+		implementations[w] = strings.Trim(test.RunIn, " \n")
+	}
+	return implementations, nil
+}
+
+//dependenciesGoTestNodes adds the edges from the images the tests run in.
+func (c *Config) dependenciesGoTestNodes(implementations map[*goTester]string) error {
+	for w, runIn := range implementations {
+		r, found := c.nameToNode[runIn]
+		if !found {
+			return fmt.Errorf("Unable to find '%s' trying to run tests '%s'", runIn, w.tag())
+		}
+		w.runIn = r
+		r.addOut(c.nameToNode[w.tag()])
+	}
+	return nil
+}
+
 func contains(list []string, candidate string) bool {
 	for _, s := range list {
 		if candidate == s {
@@ -202,6 +280,29 @@ func contains(list []string, candidate string) bool {
 func (c *Config) checkTopologyNodes(tname string, entries []*TopologyEntry) (map[*topoRunner]string, error) {
 	implementations := make(map[*topoRunner]string)
 
+	//other topologies consume its nodes as topology.node
+	if strings.Contains(tname, ".") {
+		return nil, fmt.Errorf("topology named %s can't have a . in it", strings.Trim(tname, " \n"))
+	}
+	if c.switchedOff == nil {
+		c.switchedOff = make(map[string]bool)
+	}
+	if c.activeEntries == nil {
+		c.activeEntries = make(map[string][]*TopologyEntry)
+	}
+	//the chosen profile decides what the entries are, and which of them run at all; the
+	//Topologies stay as they were written
+	active := []*TopologyEntry{}
+	for _, n := range entries {
+		if entry, on := c.profiled(n); on {
+			active = append(active, entry)
+		} else {
+			c.switchedOff[strings.Trim(tname, " \n")+"."+strings.Trim(n.Name, " \n")] = true
+		}
+	}
+	c.activeEntries[tname] = active
+	entries = active
+
 	//first pass is to establish all the names and do things that don't involve
 	//complex deps of any kind
 	for _, n := range entries {
@@ -212,6 +313,7 @@ func (c *Config) checkTopologyNodes(tname string, entries []*TopologyEntry) (map
 		if err != nil {
 			return nil, err
 		}
+		w.topo = strings.Trim(tname, " \n")
 
 		//datastructures for later
 		trimmedIn := strings.Trim(n.RunIn, " \n")
@@ -223,26 +325,84 @@ func (c *Config) checkTopologyNodes(tname string, entries []*TopologyEntry) (map
 		}
 	}
 
-	//second pass is to handle the possibility that network nodes reference
-	//each other in the consumes section of the declaration
-	for _, net := range entries {
-		info := c.nameToTopology[tname][strings.Trim(net.Name, " \n")]
-		n := info.runner.(*topoRunner)
-		for _, in := range net.Consumes {
-			trimmed := strings.Trim(in, " \n")
-			other, ok := c.nameToTopology[tname][trimmed]
-			if !ok {
-				return nil, fmt.Errorf("can't find other topo node named %s for %s (in %s)", in, n.name(), tname)
-			}
-			if other.instances > 1 {
-				return nil, fmt.Errorf("can't consume topo node %s, because there are multiple instances (%d) of it (in %s)",
-					in, other.instances, n.name())
+	return implementations, nil
+}
+
+//consumesTopologyNodes is the second pass through the network section, once every
+//topology is known.  It handles network nodes referencing each other in the consumes
+//section of the declaration.  A reference is either to a node in the same topology or,
+//as topology.node, to a node in another one; a node in another topology is shared, so
+//it is started once and kept with its own topology.
+func (c *Config) consumesTopologyNodes() error {
+	for tname, entries := range c.activeEntries {
+		t := strings.Trim(tname, " \n")
+		for _, net := range entries {
+			n := c.nameToTopology[t][strings.Trim(net.Name, " \n")].runner.(*topoRunner)
+			for _, in := range net.Consumes {
+				topo, name := t, strings.Trim(in, " \n")
+				if i := strings.Index(name, "."); i >= 0 {
+					topo, name = name[:i], name[i+1:]
+				}
+				other, ok := c.nameToTopology[topo][name]
+				if !ok && c.switchedOff[topo+"."+name] {
+					return fmt.Errorf("%s consumes %s, which is switched off (in %s)", n.name(), in, t)
+				}
+				if !ok {
+					return fmt.Errorf("can't find other topo node named %s for %s (in %s)", in, n.name(), t)
+				}
+				if other.instances > 1 {
+					return fmt.Errorf("can't consume topo node %s, because there are multiple instances (%d) of it (in %s)",
+						in, other.instances, n.name())
+				}
+				n.consumes = append(n.consumes, other.runner)
 			}
-			n.consumes = append(n.consumes, other.runner)
 		}
 	}
+	return c.checkConsumeCycles()
+}
 
-	return implementations, nil
+//checkConsumeCycles makes sure no topology node consumes itself, through any number of
+//others.  A node runs what it consumes first, so a cycle would never finish starting.
+func (c *Config) checkConsumeCycles() error {
+	names := []string{}
+	for tname := range c.activeEntries {
+		names = append(names, tname)
+	}
+	sort.Strings(names)
+	done := make(map[*topoRunner]bool)
+	var visit func(n *topoRunner, path []*topoRunner) error
+	visit = func(n *topoRunner, path []*topoRunner) error {
+		for i, p := range path {
+			if p != n {
+				continue
+			}
+			cycle := []string{}
+			for _, r := range append(path[i:], n) {
+				cycle = append(cycle, r.topology()+"."+r.name())
+			}
+			return fmt.Errorf("topology nodes consume each other: %s", strings.Join(cycle, " -> "))
+		}
+		if done[n] {
+			return nil
+		}
+		for _, r := range n.consumes {
+			if err := visit(r.(*topoRunner), append(path, n)); err != nil {
+				return err
+			}
+		}
+		done[n] = true
+		return nil
+	}
+	for _, tname := range names {
+		t := strings.Trim(tname, " \n")
+		for _, entry := range c.activeEntries[tname] {
+			n := c.nameToTopology[t][strings.Trim(entry.Name, " \n")].runner.(*topoRunner)
+			if err := visit(n, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 //this works out to the third pass threough the network section.  this is to allow
@@ -251,9 +411,6 @@ func (c *Config) checkTopologyNodes(tname string, entries []*TopologyEntry) (map
 func (c *Config) dependenciesTopologyNodes(n string, implementations map[*topoRunner]string) error {
 	//walk the know networks
 	for n, runIn := range implementations {
-		if !c.tagExists(runIn, c.cli) {
-			return fmt.Errorf("unable to find image '%s' to run (network) %s in!", runIn, n.name())
-		}
 		n.runIn.name = runIn
 		node, ok := c.nameToNode[runIn]
 		if ok {
@@ -293,21 +450,22 @@ func (c *Config) newTopoRunner(n *TopologyEntry) (*topoRunner, error) {
 		devs:   n.Devices,
 		priv:   n.Privileged,
 		wait:   n.WaitFor,
+		digest: inputDigest(n),
 	}
 	pol := defaultPolicy()
-	switch strings.ToUpper(n.Policy) {
-	case "BY_HAND":
+	switch strings.ToUpper(strings.Trim(n.Policy, " \n")) {
+	case POLICY_BY_HAND:
 		pol.startIfNonExistant = false
 		pol.stop = NEVER
 		pol.rebuildIfOOD = false
-	case "KEEP_UP":
+	case POLICY_KEEP_UP:
 		pol.stop = NEVER
-	case "CONTINUE":
+	case POLICY_CONTINUE:
 		pol.stop = NEVER
 		pol.start = CONTINUE
-	case "FRESH", "": //we allow an empty string to mean FRESH
+	case POLICY_FRESH, "": //we allow an empty string to mean FRESH
 		//nothing to do, its all defaults
-	case "ALWAYS":
+	case POLICY_ALWAYS:
 		pol.stop = ALWAYS
 	default:
 		return nil, fmt.Errorf("unknown policy %s chosen for %s", n.Policy, n.Name)
@@ -330,29 +488,69 @@ func (c *Config) newContainerBuilder(src *Container, helper pickett_io.Helper) (
 		tagname:    strings.Trim(src.Tag, "\n "),
 		dir:        strings.Trim(src.Directory, "\n "),
 		repository: strings.Trim(src.Repository, "\n "),
+		digest:     inputDigest(src),
+		buildArgs:  src.BuildArgs,
+		template:   src.Template,
 	}
-	_, err := helper.OpenDockerfileRelative(src.Directory)
+	reader, err := helper.OpenDockerfileRelative(src.Directory)
 	if err != nil {
 		return nil, fmt.Errorf("looked for %s/Dockerfile: %v",
 			helper.DirectoryRelative(src.Directory), err)
 	}
+	//the Dockerfile says what the image is built from
+	if reader != nil {
+		node.dockerFile, err = ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s/Dockerfile: %v", helper.DirectoryRelative(src.Directory), err)
+		}
+	}
 	return node, nil
 }
 
 // newGoBuilder returns a goBuilder from the configuration information
 // provided in the pickett file. This sanity checks the config file, so it can
 // fail.  It ignores dependency edges.
-func (c *Config) newGoBuilder(build *GoBuild) (*goBuilder, error) {
+func (c *Config) newGoBuilder(build *GoBuild, platform string) (*goBuilder, error) {
 	if build.Repository == "" || build.Tag == "" {
 		return nil, fmt.Errorf("repository and tag are required for a go build")
 	}
 	result := &goBuilder{
 		tagname:    strings.Trim(build.Tag, "\n "),
 		repository: strings.Trim(build.Repository, "\n "),
+		digest:     inputDigest(build),
+		parallel:   build.Parallel,
+	}
+	outputs := build.Outputs
+	if platform != "" {
+		p, err := parsePlatform(platform)
+		if err != nil {
+			return nil, fmt.Errorf("go build %s: %v", result.tag(), err)
+		}
+		result.tagname = p.expand(result.tagname, true)
+		result.env = p.env()
+		result.digest = inputDigest([]interface{}{build, platform})
+		outputs = make(map[string]string)
+		for pkg, out := range build.Outputs {
+			outputs[pkg] = p.expand(out, false)
+		}
 	}
 	if len(build.Packages) == 0 {
 		return nil, fmt.Errorf("you must define at least one source package for a go build")
 	}
+	declared := build.Caches
+	if strings.Trim(build.ModuleDir, " \n") != "" {
+		declared = withModuleCache(declared)
+	}
+	caches, err := newCacheMounts(result.tag(), declared)
+	if err != nil {
+		return nil, err
+	}
+	result.caches = caches
+	image, err := newImageConfig(result.tag(), build.Image)
+	if err != nil {
+		return nil, err
+	}
+	result.image = image
 	result.pkgs = build.Packages
 	if build.Command != "" {
 		result.command = build.Command
@@ -367,6 +565,33 @@ func (c *Config) newGoBuilder(build *GoBuild) (*goBuilder, error) {
 	} else {
 		result.probe = "go install -n"
 	}
+	if build.ModuleDir == "" {
+		if len(build.Outputs) != 0 {
+			return nil, fmt.Errorf("Outputs in go build %s require a ModuleDir", result.tag())
+		}
+		return result, nil
+	}
+
+	//module mode: staleness comes from the module's source, not probes
+	if build.TestFile != "" || build.Probe != "" {
+		return nil, fmt.Errorf("go build %s has a ModuleDir, so it can't have a TestFile or Probe", result.tag())
+	}
+	result.moduleDir = strings.Trim(build.ModuleDir, " \n")
+	result.outputCmd = result.command
+	if build.Command == "" {
+		//go install can't be given where to write a program, go build can
+		result.outputCmd = "go build"
+	}
+	result.outputs = make(map[string]string)
+	for pkg, out := range outputs {
+		if !contains(result.pkgs, pkg) {
+			return nil, fmt.Errorf("output %s in go build %s is for %s, which is not one of its Packages", out, result.tag(), pkg)
+		}
+		if !filepath.IsAbs(out) {
+			return nil, fmt.Errorf("output %s in go build %s must be an absolute path", out, result.tag())
+		}
+		result.outputs[pkg] = out
+	}
 	return result, nil
 }
 
@@ -381,6 +606,62 @@ func (c *Config) newExtractionBuilder(build *Extraction) (*extractionBuilder, er
 		artifacts:  build.Artifacts,
 		tagname:    strings.Trim(build.Tag, "\n "),
 		repository: strings.Trim(build.Repository, "\n "),
+		digest:     inputDigest(build),
+	}
+	if _, err := worker.toCopyArtifacts(); err != nil {
+		return nil, fmt.Errorf("%s: %v", worker.tag(), err)
 	}
+	image, err := newImageConfig(worker.tag(), build.Image)
+	if err != nil {
+		return nil, err
+	}
+	worker.image = image
 	return worker, nil
 }
+
+// newGoTester returns a goTester from the configuration information
+// provided.
+func (c *Config) newGoTester(test *GoTest) (*goTester, error) {
+	result := &goTester{
+		name:      strings.Trim(test.Name, " \n"),
+		pkgs:      test.Packages,
+		moduleDir: strings.Trim(test.ModuleDir, " \n"),
+		command:   strings.Trim(test.Command, " \n"),
+		format:    strings.ToUpper(strings.Trim(test.Format, " \n")),
+		reportDir: strings.Trim(test.ReportDir, " \n"),
+		digest:    inputDigest(test),
+	}
+	if result.name == "" || strings.Trim(test.RunIn, " \n") == "" {
+		return nil, fmt.Errorf("name and RunIn are required for go tests")
+	}
+	switch result.format {
+	case "", TEST_FORMAT_JSON:
+		result.format = TEST_FORMAT_JSON
+		if len(result.pkgs) == 0 {
+			return nil, fmt.Errorf("you must define at least one package for go test %s", result.name)
+		}
+		if result.command == "" {
+			result.command = "go test -json"
+		}
+	case TEST_FORMAT_JUNIT:
+		if result.command == "" {
+			return nil, fmt.Errorf("go test %s produces JUnit XML, so it needs a Command", result.name)
+		}
+	default:
+		return nil, fmt.Errorf("unknown Format %s for go test %s, should be %s or %s",
+			test.Format, result.name, TEST_FORMAT_JSON, TEST_FORMAT_JUNIT)
+	}
+	if result.reportDir == "" {
+		result.reportDir = DEFAULT_REPORT_DIR
+	}
+	declared := test.Caches
+	if result.moduleDir != "" {
+		declared = withModuleCache(declared)
+	}
+	caches, err := newCacheMounts(result.name, declared)
+	if err != nil {
+		return nil, err
+	}
+	result.caches = caches
+	return result, nil
+}
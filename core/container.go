@@ -1,8 +1,11 @@
 package pickett
 
 import (
-	"github.com/igneous-systems/pickett/io"
+	"fmt"
+	"strings"
 	"time"
+
+	"github.com/igneous-systems/pickett/io"
 )
 
 //containerBuilder represents a node in the dependency graph that understands
@@ -14,6 +17,12 @@ type containerBuilder struct {
 	imgTime    time.Time
 	dirTime    time.Time
 	inEdges    []node
+	digest     string
+	buildArgs  map[string]string
+	template   bool
+	dockerFile []byte
+	refs       []string
+	inferred   []string
 }
 
 func (c *containerBuilder) tag() string {
@@ -76,6 +85,15 @@ func (d *containerBuilder) ood(conf *Config) (time.Time, bool, error) {
 		return time.Time{}, true, nil
 	}
 
+	//build arguments and templates can change without the directory changing
+	if len(d.buildArgs) != 0 || d.template {
+		insp, err := conf.cli.InspectImage(d.tag())
+		if err == nil && insp.Labels()[io.LABEL_DIGEST] != d.digest {
+			flog.Infof("'%s' needs to be rebuilt, its build arguments or Dockerfile have changed.", d.tag())
+			return time.Time{}, true, nil
+		}
+	}
+
 	flog.Infof("'%s' is up to date with respect to its build directory.", d.tag())
 	return d.imgTime, false, nil
 }
@@ -87,6 +105,11 @@ func (d *containerBuilder) build(config *Config) (time.Time, error) {
 	opts := &io.BuildConfig{
 		NoCache:                  config.DockerBuildOptions.DontUseCache,
 		RemoveTemporaryContainer: config.DockerBuildOptions.RemoveContainer,
+		Labels:                   config.labels(d.tag(), CONTAINER_NODE, "", 0, d.digest),
+		BuildArgs:                d.buildArgs,
+	}
+	if d.template {
+		opts.Dockerfile = d.dockerFile
 	}
 	dirName := config.helper.DirectoryRelative(d.dir)
 	flog.Infof("Building tarball in %s", d.dir)
@@ -102,6 +125,50 @@ func (d *containerBuilder) build(config *Config) (time.Time, error) {
 	return d.imgTime, nil
 }
 
+//resolve expands the references in the build arguments and, for a template, in the
+//Dockerfile, and finds the nodes that they refer to.  The containers named by the FROM
+//and COPY --from instructions of the Dockerfile are inferred dependencies.  The digest
+//then covers what is actually sent to docker.
+func (d *containerBuilder) resolve(conf *Config) error {
+	args := make(map[string]string)
+	for name, value := range d.buildArgs {
+		expanded, nodes, err := conf.expandRefs(value)
+		if err != nil {
+			return fmt.Errorf("build argument %s: %v", name, err)
+		}
+		args[name] = expanded
+		d.refs = append(d.refs, nodes...)
+	}
+	d.buildArgs = args
+	if d.template {
+		expanded, nodes, err := conf.expandRefs(string(d.dockerFile))
+		if err != nil {
+			return fmt.Errorf("Dockerfile: %v", err)
+		}
+		d.dockerFile = []byte(expanded)
+		d.refs = append(d.refs, nodes...)
+	}
+	for _, ref := range dockerfileRefs(d.dockerFile, d.buildArgs) {
+		name := imageNodeName(ref)
+		if _, ok := conf.nameToNode[name]; ok && name != d.tag() && !contains(d.inferred, name) {
+			d.inferred = append(d.inferred, name)
+		}
+	}
+	if len(d.buildArgs) != 0 || d.template {
+		d.digest = inputDigest([]interface{}{d.digest, d.buildArgs, string(d.dockerFile)})
+	}
+	return nil
+}
+
+//imageNodeName returns the name of the node that would make the image ref, which is
+//the same thing with the tag "latest" if it has none.
+func imageNodeName(ref string) string {
+	if strings.LastIndex(ref, ":") <= strings.LastIndex(ref, "/") {
+		return ref + ":latest"
+	}
+	return ref
+}
+
 func (s *containerBuilder) in() []node {
 	return s.inEdges
 }
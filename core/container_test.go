@@ -1,6 +1,8 @@
 package pickett
 
 import (
+	"fmt"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -31,6 +33,7 @@ func TestAfterBuildTimeIsUpdated(t *testing.T) {
 
 	//fake out the directory "true" path
 	helper.EXPECT().DirectoryRelative(MYDIR).Return(DIR)
+	setupForLabels(helper)
 
 	//ignoring error is ok because tested in TestConf
 	c, _ := NewConfig(strings.NewReader(example1), helper, cli, etcd)
@@ -54,7 +57,10 @@ func TestAfterBuildTimeIsUpdated(t *testing.T) {
 	cli.EXPECT().InspectImage(BLETCH).Return(nowStamp, nil).After(first)
 
 	//get this after the first time check comparing directry time to hourStamp
-	cli.EXPECT().CmdBuild(gomock.Any(), DIR, BLETCH).Return(nil)
+	var labels map[string]string
+	cli.EXPECT().CmdBuild(gomock.Any(), DIR, BLETCH).Do(func(conf *io.BuildConfig, dir string, tag string) {
+		labels = conf.Labels
+	}).Return(nil)
 
 	///
 	//at start, we don't know antyhing about the time
@@ -72,4 +78,142 @@ func TestAfterBuildTimeIsUpdated(t *testing.T) {
 		t.Fatalf("failed to update the time correctly: %v\n", node.time())
 	}
 
+	//
+	// the image should be labelled with where it came from
+	//
+	if labels[io.LABEL_NODE] != BLETCH || labels[io.LABEL_TYPE] != CONTAINER_NODE {
+		t.Errorf("bad node labels on built image: %v", labels)
+	}
+	if labels[io.LABEL_PROJECT] != "baz" || labels[io.LABEL_REVISION] != "0123456789abcdef" {
+		t.Errorf("bad provenance labels on built image: %v", labels)
+	}
+	if _, ok := labels[io.LABEL_TOPOLOGY]; ok {
+		t.Errorf("built image should not have a topology label: %v", labels)
+	}
+
+}
+
+var templateExample = `
+{
+	"Variables" : { "version" : "1.4" },
+	"Containers" : [
+		{ "Repository": "base", "Tag" : "1", "Directory" : "basedir" },
+		{
+			"Repository": "app",
+			"Tag" : "latest",
+			"Directory" : "appdir",
+			"Template" : true,
+			"BuildArgs" : { "VERSION" : "v${ version }", "HOME_DIR" : "${env:PICKETT_TEST_HOME}", "LITERAL" : "$${HOME}" }
+		}
+	]
+}
+`
+
+func TestContainerBuildArgsAndTemplate(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	helper := io.NewMockHelper(controller)
+	etcd := io.NewMockEtcdClient(controller)
+	setupForLabels(helper)
+	setupForUserConfig(helper)
+
+	old, wasSet := os.LookupEnv("PICKETT_TEST_HOME")
+	os.Setenv("PICKETT_TEST_HOME", "/home/gredo")
+	defer func() {
+		if wasSet {
+			os.Setenv("PICKETT_TEST_HOME", old)
+		} else {
+			os.Unsetenv("PICKETT_TEST_HOME")
+		}
+	}()
+
+	helper.EXPECT().OpenDockerfileRelative("basedir").Return(nil, nil)
+	helper.EXPECT().OpenDockerfileRelative("appdir").Return(
+		strings.NewReader("FROM ${node:base:1}\nARG VERSION\nRUN echo $${VERSION}\n"), nil)
+	c, err := NewConfig(strings.NewReader(templateExample), helper, cli, etcd)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	app := c.nameToNode["app:latest"]
+	if len(app.implementation().in()) != 1 || app.implementation().in()[0].name() != "base:1" {
+		t.Errorf("expected the FROM in the template to imply a dependency on base:1")
+	}
+
+	var conf *io.BuildConfig
+	helper.EXPECT().DirectoryRelative("appdir").Return("/foo/bar/baz/appdir")
+	cli.EXPECT().CmdBuild(gomock.Any(), "/foo/bar/baz/appdir", "app:latest").Do(
+		func(c *io.BuildConfig, dir string, tag string) { conf = c }).Return(nil)
+	cli.EXPECT().InspectImage("app:latest").Return(nil, fmt.Errorf("no such image"))
+	if _, err := app.implementation().build(c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conf.BuildArgs["VERSION"] != "v1.4" || conf.BuildArgs["HOME_DIR"] != "/home/gredo" || conf.BuildArgs["LITERAL"] != "${HOME}" {
+		t.Errorf("build arguments not expanded: %v", conf.BuildArgs)
+	}
+	if !strings.HasPrefix(string(conf.Dockerfile), "FROM base:1\nARG VERSION\nRUN echo ${VERSION}") {
+		t.Errorf("Dockerfile not expanded: %s", conf.Dockerfile)
+	}
+
+	bad := strings.Replace(templateExample, "${ version }", "${ nonesuch }", 1)
+	helper.EXPECT().OpenDockerfileRelative(gomock.Any()).Return(nil, nil).AnyTimes()
+
+	//--set wins over the Variables of the configuration
+	c, err = NewConfigWithOptions(strings.NewReader(templateExample), helper, cli, etcd, &ConfigOptions{Set: []string{"version=2.0"}})
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	if value, _, err := c.expandRefs("v${version}"); err != nil || value != "v2.0" {
+		t.Errorf("expected --set to win over Variables, got %s (%v)", value, err)
+	}
+
+	if _, err := NewConfig(strings.NewReader(bad), helper, cli, etcd); err == nil {
+		t.Errorf("expected an unknown variable to be an error")
+	}
+}
+
+var inferExample = `
+{
+	"Containers" : [
+		{ "Repository": "base", "Tag" : "1", "Directory" : "basedir" },
+		{ "Repository": "tools", "Tag" : "latest", "Directory" : "toolsdir" },
+		{ "Repository": "app", "Tag" : "latest", "Directory" : "appdir" }
+	]
+}
+`
+
+func TestContainerDependenciesInferred(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	helper := io.NewMockHelper(controller)
+	etcd := io.NewMockEtcdClient(controller)
+	setupForLabels(helper)
+	setupForUserConfig(helper)
+
+	helper.EXPECT().OpenDockerfileRelative("basedir").Return(nil, nil)
+	helper.EXPECT().OpenDockerfileRelative("toolsdir").Return(strings.NewReader("FROM ubuntu:14.04\n"), nil)
+	helper.EXPECT().OpenDockerfileRelative("appdir").Return(strings.NewReader(`ARG BASE=base:1
+FROM ${BASE} AS build
+RUN make
+FROM scratch
+COPY --from=build /out /app
+COPY --from=tools /usr/bin/tini /tini
+`), nil)
+	c, err := NewConfig(strings.NewReader(inferExample), helper, cli, etcd)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	in := []string{}
+	for _, n := range c.nameToNode["app:latest"].implementation().in() {
+		in = append(in, n.name())
+	}
+	if len(in) != 2 || !contains(in, "base:1") || !contains(in, "tools:latest") {
+		t.Errorf("expected app to depend on base:1 and tools:latest, but got %v", in)
+	}
+	if len(c.nameToNode["tools:latest"].implementation().in()) != 0 {
+		t.Errorf("tools should not depend on anything")
+	}
 }
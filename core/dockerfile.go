@@ -0,0 +1,115 @@
+package pickett
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//These start the references that can be used in build arguments and templated
+//Dockerfiles, besides the ${name} of a variable that the rest of the configuration has.
+//${env:NAME} is an environment variable and ${node:name} is the image made by another
+//node.
+const (
+	REF_ENV  = "env:"
+	REF_NODE = "node:"
+)
+
+//expandRefs replaces the references in s with their values.  The names of the nodes
+//referred to are returned, since whatever uses s depends on them.
+func (c *Config) expandRefs(s string) (string, []string, error) {
+	nodes := []string{}
+	result, err := expandReferences(s, func(ref string) (string, error) {
+		switch {
+		case strings.HasPrefix(ref, REF_ENV):
+			name := strings.TrimPrefix(ref, REF_ENV)
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				return "", fmt.Errorf("environment variable %s is not set", name)
+			}
+			return value, nil
+		case strings.HasPrefix(ref, REF_NODE):
+			name := strings.TrimPrefix(ref, REF_NODE)
+			if _, ok := c.nameToNode[name]; !ok {
+				return "", fmt.Errorf("no node called %s", name)
+			}
+			nodes = append(nodes, name)
+			return name, nil
+		}
+		if c.variables == nil {
+			return "", fmt.Errorf("no variable called %s", ref)
+		}
+		return c.variables.value(ref)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return result, nodes, nil
+}
+
+//dockerfileRefs returns the images a Dockerfile refers to, in FROM instructions and in
+//the --from of COPY instructions.  Stages of a multi-stage build are not images, nor is
+//scratch.  References to build arguments are replaced using args and the defaults of the
+//ARG instructions; anything still not known is left out.
+func dockerfileRefs(dockerFile []byte, args map[string]string) []string {
+	result := []string{}
+	stages := map[string]bool{"scratch": true}
+	values := make(map[string]string)
+	for k, v := range args {
+		values[k] = v
+	}
+	add := func(ref string) {
+		ref = os.Expand(ref, func(name string) string {
+			v, ok := values[name]
+			if !ok {
+				return "$"
+			}
+			return v
+		})
+		if ref == "" || strings.Contains(ref, "$") || stages[strings.ToLower(ref)] || contains(result, ref) {
+			return
+		}
+		if _, err := strconv.Atoi(ref); err == nil {
+			return //COPY --from=0 is a stage by number
+		}
+		result = append(result, ref)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(dockerFile))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			parts := strings.SplitN(fields[1], "=", 2)
+			if _, ok := values[parts[0]]; !ok && len(parts) == 2 {
+				values[parts[0]] = strings.Trim(parts[1], `"'`)
+			}
+		case "FROM":
+			args := []string{}
+			for _, f := range fields[1:] {
+				if !strings.HasPrefix(f, "--") {
+					args = append(args, f)
+				}
+			}
+			if len(args) == 0 {
+				continue
+			}
+			add(args[0])
+			if len(args) == 3 && strings.ToUpper(args[1]) == "AS" {
+				stages[strings.ToLower(args[2])] = true
+			}
+		case "COPY":
+			for _, f := range fields[1:] {
+				if strings.HasPrefix(f, "--from=") {
+					add(strings.TrimPrefix(f, "--from="))
+				}
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,319 @@
+package pickett
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+//problem is something the doctor found to be wrong.  If repair is nil, there is
+//nothing we can do about it automatically.
+type problem struct {
+	description string
+	repair      func() error
+}
+
+//instanceRecord is everything the state store knows about one instance of a
+//topology entry, keyed by the kind of record (CONTAINERS, IPS, PORTS).
+type instanceRecord struct {
+	topology string
+	node     string
+	instance string
+	values   map[string]string
+}
+
+func (r *instanceRecord) String() string {
+	return fmt.Sprintf("%s.%s[%s]", r.topology, r.node, r.instance)
+}
+
+// CmdDoctor compares what the state store believes about the running topologies with
+// what the docker daemon actually has, and checks the environment pickett is running in.
+// If etcdErr is not nil, the state store was unreachable and config has no etcd client.
+// Each problem that can be fixed is repaired if fix is true, otherwise the user is asked.
+func CmdDoctor(fix bool, etcdErr error, config *Config) error {
+	problems := config.checkEnvironment()
+	if etcdErr != nil {
+		problems = append(problems, &problem{
+			description: fmt.Sprintf("state store (etcd) is unreachable: %v", etcdErr),
+		})
+	} else {
+		found, err := config.checkStateStore()
+		if err != nil {
+			return err
+		}
+		problems = append(problems, found...)
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("[pickett] no problems found\n")
+		return nil
+	}
+	remaining := 0
+	for _, p := range problems {
+		fmt.Printf("[pickett] PROBLEM: %s\n", p.description)
+		if p.repair == nil || !(fix || askToRepair()) {
+			remaining++
+			continue
+		}
+		if err := p.repair(); err != nil {
+			fmt.Printf("[pickett] repair failed: %v\n", err)
+			remaining++
+			continue
+		}
+		fmt.Printf("[pickett] repaired\n")
+	}
+	if remaining != 0 {
+		return fmt.Errorf("%d of %d problems remain", remaining, len(problems))
+	}
+	return nil
+}
+
+//askToRepair asks the user on the terminal if we should repair a problem.
+func askToRepair() bool {
+	fmt.Printf("[pickett] repair? [y/N] ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+//checkEnvironment looks at the things around pickett that need to be right for it to
+//work: DOCKER_HOST, the version of the daemon, and path translation.
+func (c *Config) checkEnvironment() []*problem {
+	result := []*problem{}
+	if err := io.ValidateDockerHost(); err != nil {
+		result = append(result, &problem{
+			description: fmt.Sprintf("DOCKER_HOST (%s) is not usable: %v", os.Getenv("DOCKER_HOST"), err),
+		})
+	}
+	version, err := c.cli.ServerVersion()
+	if err != nil {
+		result = append(result, &problem{
+			description: fmt.Sprintf("unable to get the version of the docker daemon: %v", err),
+		})
+	} else if !io.APIVersionAtLeast(version.ApiVersion, io.MIN_API_VERSION) {
+		result = append(result, &problem{
+			description: fmt.Sprintf("docker daemon %s has API version %s, but pickett needs at least %s",
+				version.Version, version.ApiVersion, io.MIN_API_VERSION),
+		})
+	}
+	if _, err := c.translator(); err != nil {
+		result = append(result, &problem{
+			description: fmt.Sprintf("path translation is not configured correctly: %v", err),
+		})
+		return result
+	}
+	for _, v := range c.CodeVolumes {
+		if v.Mode == CODE_VOLUME_COPY {
+			continue
+		}
+		dir := c.helper.DirectoryRelative(v.Directory)
+		if _, err := c.translate(dir); err != nil {
+			result = append(result, &problem{
+				description: fmt.Sprintf("code volume %s can't be translated to a path on the docker host (consider Mode %s): %v", dir, CODE_VOLUME_COPY, err),
+			})
+		}
+	}
+	return result
+}
+
+//readInstanceRecords walks the state store and returns everything in it about the
+//instances of topology entries, keyed by "topology/node/instance".
+func (c *Config) readInstanceRecords() (map[string]*instanceRecord, error) {
+	records := make(map[string]*instanceRecord)
+	for _, kind := range []string{CONTAINERS, IPS, PORTS} {
+		kindPath := filepath.Join(io.PICKETT_KEYSPACE, kind)
+		topos, found, err := c.etcd.Children(kindPath)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		for _, topo := range topos {
+			nodes, _, err := c.etcd.Children(filepath.Join(kindPath, topo))
+			if err != nil {
+				return nil, err
+			}
+			for _, n := range nodes {
+				instances, _, err := c.etcd.Children(filepath.Join(kindPath, topo, n))
+				if err != nil {
+					return nil, err
+				}
+				for _, inst := range instances {
+					value, found, err := c.etcd.Get(instanceKey(kind, topo, n, inst))
+					if err != nil {
+						return nil, err
+					}
+					if !found {
+						continue
+					}
+					id := filepath.Join(topo, n, inst)
+					rec, ok := records[id]
+					if !ok {
+						rec = &instanceRecord{
+							topology: topo,
+							node:     n,
+							instance: inst,
+							values:   make(map[string]string),
+						}
+						records[id] = rec
+					}
+					rec.values[kind] = value
+				}
+			}
+		}
+	}
+	return records, nil
+}
+
+//sameWords is true if the two space separated lists have the same elements, in any order.
+func sameWords(a string, b string) bool {
+	x := strings.Fields(a)
+	y := strings.Fields(b)
+	sort.Strings(x)
+	sort.Strings(y)
+	return strings.Join(x, " ") == strings.Join(y, " ")
+}
+
+//checkStateStore compares the records in the state store with the containers that the
+//docker daemon has.
+func (c *Config) checkStateStore() ([]*problem, error) {
+	result := []*problem{}
+	records, err := c.readInstanceRecords()
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	//names of the containers that are correctly recorded
+	recorded := make(map[string]bool)
+
+	for _, id := range ids {
+		rec := records[id]
+		forget := func() error {
+			return forgetInstance(rec.topology, rec.node, rec.instance, c.etcd)
+		}
+		if _, known := c.nameToTopology[rec.topology][rec.node]; !known {
+			result = append(result, &problem{
+				description: fmt.Sprintf("state store has records for %s, which is not in %s", rec, c.helper.ConfigFile()),
+				repair:      forget,
+			})
+			continue
+		}
+		cont, ok := rec.values[CONTAINERS]
+		if !ok {
+			result = append(result, &problem{
+				description: fmt.Sprintf("state store has network records for %s, but no container", rec),
+				repair:      forget,
+			})
+			continue
+		}
+		insp, err := c.cli.InspectContainer(cont)
+		if err != nil {
+			result = append(result, &problem{
+				description: fmt.Sprintf("container %s recorded for %s no longer exists", cont, rec),
+				repair:      forget,
+			})
+			continue
+		}
+		recorded[insp.ContainerName()] = true
+		if !insp.Running() {
+			//addresses are only meaningful for running containers
+			continue
+		}
+		if ip := insp.Ip(); ip != rec.values[IPS] {
+			key := instanceKey(IPS, rec.topology, rec.node, rec.instance)
+			result = append(result, &problem{
+				description: fmt.Sprintf("state store has IP '%s' for %s, but container %s has '%s'", rec.values[IPS], rec, cont, ip),
+				repair:      func() error { _, err := c.etcd.Put(key, ip); return err },
+			})
+		}
+		if ports := strings.Join(insp.Ports(), " "); !sameWords(ports, rec.values[PORTS]) {
+			key := instanceKey(PORTS, rec.topology, rec.node, rec.instance)
+			result = append(result, &problem{
+				description: fmt.Sprintf("state store has ports '%s' for %s, but container %s has '%s'", rec.values[PORTS], rec, cont, ports),
+				repair:      func() error { _, err := c.etcd.Put(key, ports); return err },
+			})
+		}
+	}
+
+	//now look for the containers we made that the state store doesn't know about
+	containers, err := c.cli.ListContainers()
+	if err != nil {
+		return nil, err
+	}
+	for _, cont := range containers {
+		labels := cont.Labels
+		if labels[io.LABEL_TYPE] != TOPOLOGY_NODE || labels[io.LABEL_CONFIG] != c.provenance().config {
+			continue
+		}
+		if len(cont.Names) == 0 {
+			continue
+		}
+		name := strings.TrimLeft(cont.Names[0], "/")
+		if recorded[name] {
+			continue
+		}
+		rec := &instanceRecord{
+			topology: labels[io.LABEL_TOPOLOGY],
+			node:     labels[io.LABEL_NODE],
+			instance: labels[io.LABEL_INSTANCE],
+		}
+		if _, taken := records[filepath.Join(rec.topology, rec.node, rec.instance)]; taken {
+			id := cont.ID
+			result = append(result, &problem{
+				description: fmt.Sprintf("container %s was created for %s, which is recorded as a different container", name, rec),
+				repair:      func() error { return c.removeContainer(id) },
+			})
+			continue
+		}
+		result = append(result, &problem{
+			description: fmt.Sprintf("container %s was created for %s, but is not recorded in the state store", name, rec),
+			repair:      func() error { return c.recordContainer(name, rec) },
+		})
+	}
+	return result, nil
+}
+
+//removeContainer stops (if needed) and removes a container.
+func (c *Config) removeContainer(id string) error {
+	insp, err := c.cli.InspectContainer(id)
+	if err != nil {
+		return err
+	}
+	if insp.Running() {
+		if err := c.cli.CmdStop(id); err != nil {
+			return err
+		}
+	}
+	return c.cli.CmdRmContainer(id)
+}
+
+//recordContainer puts the records about a container into the state store, as if
+//we had just started it.
+func (c *Config) recordContainer(name string, rec *instanceRecord) error {
+	insp, err := c.cli.InspectContainer(name)
+	if err != nil {
+		return err
+	}
+	if _, err := c.etcd.Put(instanceKey(CONTAINERS, rec.topology, rec.node, rec.instance), insp.ContainerName()); err != nil {
+		return err
+	}
+	if _, err := c.etcd.Put(instanceKey(IPS, rec.topology, rec.node, rec.instance), insp.Ip()); err != nil {
+		return err
+	}
+	_, err = c.etcd.Put(instanceKey(PORTS, rec.topology, rec.node, rec.instance), strings.Join(insp.Ports(), " "))
+	return err
+}
@@ -0,0 +1,70 @@
+package pickett
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+	"github.com/igneous-systems/pickett/io"
+)
+
+func TestDoctorRepairsStateStore(T *testing.T) {
+	controller := gomock.NewController(T)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+
+	oldHost := os.Getenv("DOCKER_HOST")
+	os.Setenv("DOCKER_HOST", "tcp://localhost:2375")
+	defer os.Setenv("DOCKER_HOST", oldHost)
+
+	//called as part of config check
+	helper.EXPECT().OpenDockerfileRelative("somedir").Return(nil, nil)
+	setupForLabels(helper)
+	setupForUserConfig(helper)
+
+	c, err := NewConfig(strings.NewReader(netExample), helper, cli, etcd)
+	if err != nil {
+		T.Fatalf("can't parse legal config file: %v", err)
+	}
+
+	cli.EXPECT().ServerVersion().Return(&io.ServerVersion{Version: "1.6.0", ApiVersion: "1.18"}, nil)
+
+	//the state store has a record for a node that is gone from the config, network
+	//records for part3 without a container, and no IP for part4
+	etcd.EXPECT().Children("/pickett/containers").Return([]string{"someothergraph"}, true, nil)
+	etcd.EXPECT().Children("/pickett/containers/someothergraph").Return([]string{"part4", "gone"}, true, nil)
+	etcd.EXPECT().Children("/pickett/containers/someothergraph/part4").Return([]string{"0"}, true, nil)
+	etcd.EXPECT().Children("/pickett/containers/someothergraph/gone").Return([]string{"0"}, true, nil)
+	etcd.EXPECT().Get("/pickett/containers/someothergraph/part4/0").Return("fried_hendrix", true, nil)
+	etcd.EXPECT().Get("/pickett/containers/someothergraph/gone/0").Return("shot_lennon", true, nil).Times(2)
+	etcd.EXPECT().Children("/pickett/ips").Return([]string{"someothergraph"}, true, nil)
+	etcd.EXPECT().Children("/pickett/ips/someothergraph").Return([]string{"part3"}, true, nil)
+	etcd.EXPECT().Children("/pickett/ips/someothergraph/part3").Return([]string{"0"}, true, nil)
+	etcd.EXPECT().Get("/pickett/ips/someothergraph/part3/0").Return("1.2.3.4", true, nil).Times(2)
+	etcd.EXPECT().Children("/pickett/ports").Return(nil, false, nil)
+
+	hendrix := io.NewMockInspectedContainer(controller)
+	hendrix.EXPECT().ContainerName().Return("fried_hendrix")
+	hendrix.EXPECT().Running().Return(true)
+	hendrix.EXPECT().Ip().Return("10.0.0.1")
+	hendrix.EXPECT().Ports().Return([]string{})
+	cli.EXPECT().InspectContainer("fried_hendrix").Return(hendrix, nil)
+	cli.EXPECT().ListContainers().Return(nil, nil)
+
+	//repairs
+	etcd.EXPECT().Del("/pickett/containers/someothergraph/gone/0").Return("shot_lennon", nil)
+	etcd.EXPECT().Get("/pickett/ips/someothergraph/gone/0").Return("", false, nil)
+	etcd.EXPECT().Get("/pickett/ports/someothergraph/gone/0").Return("", false, nil)
+	etcd.EXPECT().Get("/pickett/containers/someothergraph/part3/0").Return("", false, nil)
+	etcd.EXPECT().Del("/pickett/ips/someothergraph/part3/0").Return("1.2.3.4", nil)
+	etcd.EXPECT().Get("/pickett/ports/someothergraph/part3/0").Return("", false, nil)
+	etcd.EXPECT().Put("/pickett/ips/someothergraph/part4/0", "10.0.0.1").Return("", nil)
+
+	if err := CmdDoctor(true, nil, c); err != nil {
+		T.Fatalf("expected all problems to be repaired: %v", err)
+	}
+}
@@ -2,7 +2,10 @@ package pickett
 
 import (
 	"fmt"
+	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,6 +22,8 @@ type extractionBuilder struct {
 	runIn      nodeOrName
 	mergeWith  nodeOrName
 	artifacts  []*Artifact
+	digest     string
+	image      *io.ImageConfig
 }
 
 func (e *extractionBuilder) tag() string {
@@ -28,6 +33,9 @@ func (e *extractionBuilder) tag() string {
 // IsOutOfDate returns true if the tag that we are trying to produce is
 // before the tag of the image we depend on.
 func (e *extractionBuilder) ood(conf *Config) (time.Time, bool, error) {
+	if err := e.checkImages(conf); err != nil {
+		return time.Time{}, true, err
+	}
 	t, err := tagToTime(e.tag(), conf.cli)
 	if err != nil {
 		return time.Time{}, true, err
@@ -95,19 +103,17 @@ func (e *extractionBuilder) ood(conf *Config) (time.Time, bool, error) {
 //the source directories.  Things that are have to handled specially by various parts of the extraction.
 func (e *extractionBuilder) getSourceExtractions(conf *Config) (time.Time, map[string]string, error) {
 
-	//note that this is NOT path translated for the virtual machine!!
-	volumes := make(map[string]string)
-	for _, cv := range conf.CodeVolumes {
-		dir := conf.helper.DirectoryRelative(cv.Directory)
-		volumes[dir] = cv.MountedAt
-	}
+	//note that this is NOT path translated for the docker daemon, these artifacts are
+	//read from this machine
+	volumes := conf.hostCodeVolumes()
 
 	//this is keyed by the source of the artifacts
 	realPathSource := make(map[string]string)
 
 	// we have to detect things in the mounted volumes
+	//a pattern is looked for below the directory it starts with
 	for _, a := range e.artifacts {
-		candidateIn := filepath.Clean(a.BuiltPath)
+		candidateIn := io.GlobRoot(a.BuiltPath)
 		candidateOut := filepath.Clean(a.DestinationDir)
 
 		for k, v := range volumes {
@@ -143,20 +149,83 @@ func (e *extractionBuilder) toCopyArtifacts() ([]*io.CopyArtifact, error) {
 			return art, fmt.Errorf("An artifact must have a DestinationDir & a BuildPath defined !")
 		}
 		cp := &io.CopyArtifact{
-			SourcePath:     a.BuiltPath,
-			DestinationDir: a.DestinationDir,
+			SourcePath:      a.BuiltPath,
+			DestinationDir:  a.DestinationDir,
+			DestinationName: strings.Trim(a.DestinationName, " \n"),
+			Excludes:        a.Exclude,
+			Flatten:         a.Flatten,
+		}
+		for _, p := range append([]string{a.BuiltPath}, a.Exclude...) {
+			if _, err := path.Match(p, ""); err != nil {
+				return art, fmt.Errorf("bad pattern %s in artifact %s", p, a.BuiltPath)
+			}
+		}
+		if strings.Contains(cp.DestinationName, "/") {
+			return art, fmt.Errorf("DestinationName %s of artifact %s must be a file name, not a path", cp.DestinationName, a.BuiltPath)
+		}
+		if mode := strings.Trim(a.Mode, " \n"); mode != "" {
+			m, err := strconv.ParseUint(mode, 8, 32)
+			if err != nil || m > 07777 {
+				return art, fmt.Errorf("bad Mode %s for artifact %s, should be octal like 0755", a.Mode, a.BuiltPath)
+			}
+			cp.Mode = os.FileMode(m)
+		}
+		if owner := strings.Trim(a.Owner, " \n"); owner != "" {
+			uid, gid, err := parseOwner(owner)
+			if err != nil {
+				return art, fmt.Errorf("bad Owner %s for artifact %s: %v", a.Owner, a.BuiltPath, err)
+			}
+			cp.Chown, cp.Uid, cp.Gid = true, uid, gid
 		}
 		art = append(art, cp)
 	}
 	return art, nil
 }
 
+//parseOwner reads an owner given as uid or uid:gid.  Without a gid, the group is
+//the same number as the user.
+func parseOwner(owner string) (int, int, error) {
+	parts := strings.Split(owner, ":")
+	if len(parts) > 2 {
+		return 0, 0, fmt.Errorf("should be uid or uid:gid")
+	}
+	ids := []int{}
+	for _, p := range parts {
+		id, err := strconv.Atoi(p)
+		if err != nil || id < 0 {
+			return 0, 0, fmt.Errorf("should be numeric uid or uid:gid")
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 1 {
+		return ids[0], ids[0], nil
+	}
+	return ids[0], ids[1], nil
+}
+
+//checkImages makes sure the images we copy from and merge into exist, if we don't build
+//them.
+func (e *extractionBuilder) checkImages(conf *Config) error {
+	if !conf.imageExists(e.runIn.name) {
+		return fmt.Errorf("Unable to find '%s' (RunIn) in extract build  '%s': maybe you need to 'docker pull' it?",
+			e.runIn.name, e.tag())
+	}
+	if !conf.imageExists(e.mergeWith.name) {
+		return fmt.Errorf("Unable to find '%s' (MergeWith) in extract build '%s': maybe you need to 'docker pull' it?",
+			e.mergeWith.name, e.tag())
+	}
+	return nil
+}
+
 //build does the work of coping data from the source image (runIn) and then
 //adding it to the merge image (mergeWith)
 func (e *extractionBuilder) build(conf *Config) (time.Time, error) {
 
 	var err error
 
+	if err := e.checkImages(conf); err != nil {
+		return time.Time{}, err
+	}
 	_, realPathSource, err := e.getSourceExtractions(conf)
 	if err != nil {
 		return time.Time{}, err
@@ -167,7 +236,12 @@ func (e *extractionBuilder) build(conf *Config) (time.Time, error) {
 		return time.Time{}, err
 	}
 
-	err = conf.cli.CmdCopy(realPathSource, e.runIn.name, e.mergeWith.name, art, e.tag())
+	imgConf := withLabels(e.image, conf.labels(e.tag(), EXTRACTION_NODE, "", 0, e.digest))
+	info := &io.TagInfo{
+		Repository: e.repository,
+		Tag:        e.tagname,
+	}
+	err = conf.cli.CmdCopy(realPathSource, e.runIn.name, e.mergeWith.name, art, info, imgConf)
 	if err != nil {
 		return time.Time{}, err
 	}
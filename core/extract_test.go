@@ -0,0 +1,157 @@
+package pickett
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/gomock/gomock"
+	"github.com/igneous-systems/pickett/io"
+)
+
+var extractExample = `
+{
+	"Containers" : [
+		{ "Repository": "golang", "Tag" : "1.12", "Directory" : "godir" }
+	],
+	"Extractions" : [
+		{
+			"Repository" : "sample",
+			"Tag" : "runner",
+			"RunIn" : "golang:1.12",
+			"MergeWith" : "ubuntu:14.04",
+			"Artifacts" : [
+				{
+					"BuiltPath" : "/go/bin/*-linux",
+					"DestinationDir" : "/usr/local/bin",
+					"Exclude" : [ "*-test-*" ],
+					"Mode" : "0755",
+					"Owner" : "1000"
+				}
+			]
+		}
+	]
+}
+`
+
+func TestExtractionArtifactOptions(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+	setupForLabels(helper)
+	setupForUserConfig(helper)
+	helper.EXPECT().OpenDockerfileRelative("godir").Return(nil, nil).AnyTimes()
+	cli.EXPECT().InspectImage("ubuntu:14.04").Return(io.NewMockInspectedImage(controller), nil).AnyTimes()
+
+	c, err := NewConfig(strings.NewReader(extractExample), helper, cli, etcd)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	e := c.nameToNode["sample:runner"].implementation().(*extractionBuilder)
+	art, err := e.toCopyArtifacts()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a := art[0]
+	if a.Mode != os.FileMode(0755) || !a.Chown || a.Uid != 1000 || a.Gid != 1000 ||
+		len(a.Excludes) != 1 || a.SourcePath != "/go/bin/*-linux" {
+		t.Errorf("artifact options not converted: %+v", a)
+	}
+
+	bad := [][]string{
+		{`"Mode" : "0755"`, `"Mode" : "rwx"`},
+		{`"Owner" : "1000"`, `"Owner" : "root"`},
+		{`"Mode" : "0755"`, `"Mode" : "0755", "DestinationName" : "a/b"`},
+		{`"/go/bin/*-linux"`, `"/go/[bin"`},
+	}
+	for _, b := range bad {
+		conf := strings.Replace(extractExample, b[0], b[1], 1)
+		if _, err := NewConfig(strings.NewReader(conf), helper, cli, etcd); err == nil {
+			t.Errorf("expected %s to be rejected", b[1])
+		}
+	}
+}
+
+func TestExtractionCommitsResultTag(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+	setupForLabels(helper)
+	setupForUserConfig(helper)
+	helper.EXPECT().OpenDockerfileRelative("godir").Return(nil, nil)
+	insp := io.NewMockInspectedImage(controller)
+	insp.EXPECT().CreatedTime().Return(time.Now()).AnyTimes()
+	cli.EXPECT().InspectImage("ubuntu:14.04").Return(insp, nil).AnyTimes()
+
+	c, err := NewConfig(strings.NewReader(extractExample), helper, cli, etcd)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	e := c.nameToNode["sample:runner"].implementation().(*extractionBuilder)
+
+	//nothing is in the source tree, so everything comes from the container
+	cli.EXPECT().CmdCopy(map[string]string{}, "golang:1.12", "ubuntu:14.04", gomock.Any(),
+		&io.TagInfo{Repository: "sample", Tag: "runner"}, gomock.Any()).Return(nil)
+	cli.EXPECT().InspectImage("sample:runner").Return(insp, nil)
+	if _, err := e.build(c); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestExtractionImageMetadata(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+	setupForLabels(helper)
+	setupForUserConfig(helper)
+	helper.EXPECT().OpenDockerfileRelative("godir").Return(nil, nil).AnyTimes()
+	insp := io.NewMockInspectedImage(controller)
+	insp.EXPECT().CreatedTime().Return(time.Now()).AnyTimes()
+	cli.EXPECT().InspectImage(gomock.Any()).Return(insp, nil).AnyTimes()
+
+	withImage := strings.Replace(extractExample, `"Artifacts" : [`, `"Image" : {
+				"Entrypoint" : [ "/usr/local/bin/server-linux" ],
+				"Env" : { "PORT" : "8080", "MODE" : "prod" },
+				"ExposedPorts" : [ "8080", "53/UDP" ],
+				"Labels" : { "com.example.team" : "weather" }
+			},
+			"Artifacts" : [`, 1)
+	c, err := NewConfig(strings.NewReader(withImage), helper, cli, etcd)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	e := c.nameToNode["sample:runner"].implementation().(*extractionBuilder)
+
+	var imgConf *io.ImageConfig
+	cli.EXPECT().CmdCopy(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Do(
+		func(r map[string]string, src, dest string, a []*io.CopyArtifact, info *io.TagInfo, conf *io.ImageConfig) {
+			imgConf = conf
+		}).Return(nil)
+	if _, err := e.build(c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(imgConf.Entrypoint) != 1 || imgConf.Env[0] != "MODE=prod" || imgConf.Env[1] != "PORT=8080" ||
+		imgConf.ExposedPorts[0] != "8080/tcp" || imgConf.ExposedPorts[1] != "53/udp" {
+		t.Errorf("image settings not applied: %+v", imgConf)
+	}
+	if imgConf.Labels["com.example.team"] != "weather" || imgConf.Labels[io.LABEL_NODE] != "sample:runner" {
+		t.Errorf("expected both our labels and pickett's: %v", imgConf.Labels)
+	}
+
+	for _, bad := range []string{`"70000"`, `"80/sctp"`} {
+		conf := strings.Replace(withImage, `"53/UDP"`, bad, 1)
+		if _, err := NewConfig(strings.NewReader(conf), helper, cli, etcd); err == nil {
+			t.Errorf("expected exposed port %s to be rejected", bad)
+		}
+	}
+}
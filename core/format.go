@@ -0,0 +1,690 @@
+package pickett
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
+)
+
+//These are the formats a configuration can be written in.
+const (
+	FORMAT_JSON = "json"
+	FORMAT_YAML = "yaml"
+	FORMAT_TOML = "toml"
+)
+
+// CONFIG_FILES are the names looked for, in order, when no configuration file is given.
+var CONFIG_FILES = []string{"Pickett.json", "Pickett.yaml", "Pickett.yml", "Pickett.toml"}
+
+// FormatOfFile returns the format of the file called name, judging by its extension, or
+// "" if the extension doesn't say.
+func FormatOfFile(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return FORMAT_JSON
+	case ".yaml", ".yml":
+		return FORMAT_YAML
+	case ".toml":
+		return FORMAT_TOML
+	}
+	return ""
+}
+
+//unnamedConfig is the name used in messages about a configuration in format that did
+//not come from a file.
+func unnamedConfig(format string) string {
+	return "Pickett." + format
+}
+
+//tomlLine is the start of a table header or a key = value line of TOML.
+var tomlLine = regexp.MustCompile(`^(\[|[A-Za-z0-9_."'-]+\s*=)`)
+
+//sniffFormat guesses the format of content from its first line that isn't blank or a
+//comment.  A JSON configuration is an object, TOML is made of tables and key = value
+//lines and anything else is taken to be YAML.
+func sniffFormat(content []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "{") || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "/*"):
+			return FORMAT_JSON
+		case tomlLine.MatchString(line):
+			return FORMAT_TOML
+		}
+		return FORMAT_YAML
+	}
+	return FORMAT_JSON
+}
+
+//configFormat decides the format of content, which came from file: by the file's
+//extension if it has a known one, or else by looking at the content.
+func configFormat(file string, content []byte) string {
+	if format := FormatOfFile(file); format != "" {
+		return format
+	}
+	return sniffFormat(content)
+}
+
+//decodeConfig decodes content, which came from file and may be in any of the formats,
+//into v.  It returns a warning for each key that has no place in v.
+func decodeConfig(file string, content []byte, v interface{}) ([]string, error) {
+	doc, file, err := parseConfig(file, content)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument(file, doc, nil, v)
+}
+
+//parseConfig parses content, which came from file and may be in any of the formats.  If
+//file is "", the content didn't come from a file and its format is guessed; the name
+//to use in messages is returned.
+func parseConfig(file string, content []byte) (*yaml.Node, string, error) {
+	format := configFormat(file, content)
+	if file == "" {
+		file = unnamedConfig(format)
+	}
+	doc, err := parseDocument(file, format, content)
+	return doc, file, err
+}
+
+//decodeDocument decodes doc, which came from file, into v.  Parts of it may have come
+//from other files, as from says.  It is turned into JSON first, so every format is
+//checked the same way and problems are reported at their place in the original file.
+//It returns a warning for each key that has no place in v.
+func decodeDocument(file string, doc *yaml.Node, from sources, v interface{}) ([]string, error) {
+	w := &jsonWriter{file: file, from: from}
+	if err := w.write(doc); err != nil {
+		return nil, err
+	}
+	return decodeJSON(file, w.buf.Bytes(), w.at, v)
+}
+
+//parseDocument parses content, which came from file and is in format, into a tree of
+//YAML nodes, which keep the order of keys and where they came from.
+func parseDocument(file string, format string, content []byte) (*yaml.Node, error) {
+	switch format {
+	case FORMAT_YAML:
+		return parseYAML(file, content)
+	case FORMAT_TOML:
+		return parseTOML(file, content)
+	}
+	return parseJSONC(file, content)
+}
+
+//yamlError is how the YAML parser reports a problem.
+var yamlError = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+func parseYAML(file string, content []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		if m := yamlError.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			return nil, &ConfigError{File: file, Line: line, Msg: m[2]}
+		}
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: 1, Column: 1}, nil
+	}
+	root := doc.Content[0]
+	if root.HeadComment == "" {
+		//the comment at the top of the file, kept with what the file has in it
+		root.HeadComment = doc.HeadComment
+	}
+	return root, nil
+}
+
+//tomlError is how the TOML parser reports a problem.
+var tomlError = regexp.MustCompile(`^\((\d+), (\d+)\): (.*)$`)
+
+func parseTOML(file string, content []byte) (*yaml.Node, error) {
+	tree, err := toml.LoadBytes(content)
+	if err != nil {
+		if m := tomlError.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			column, _ := strconv.Atoi(m[2])
+			return nil, &ConfigError{File: file, Line: line, Column: column, Msg: m[3]}
+		}
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return tomlTable(tree), nil
+}
+
+//tomlTable turns a TOML table into a mapping, with its keys in the order they were
+//written.
+func tomlTable(t *toml.Tree) *yaml.Node {
+	pos := t.Position()
+	n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: pos.Line, Column: pos.Col}
+	keyPos := func(key string) toml.Position {
+		if trees, ok := t.GetPath([]string{key}).([]*toml.Tree); ok && len(trees) > 0 {
+			return trees[0].Position()
+		}
+		return t.GetPositionPath([]string{key})
+	}
+	keys := t.Keys()
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keyPos(keys[i]), keyPos(keys[j])
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Col != b.Col {
+			return a.Col < b.Col
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys {
+		p := keyPos(key)
+		k := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key, Line: p.Line, Column: p.Col}
+		n.Content = append(n.Content, k, tomlValue(t.GetPath([]string{key}), p))
+	}
+	return n
+}
+
+//tomlValue turns a value from a TOML table, found at pos, into a node.
+func tomlValue(v interface{}, pos toml.Position) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.ScalarNode, Line: pos.Line, Column: pos.Col}
+	switch v := v.(type) {
+	case *toml.Tree:
+		return tomlTable(v)
+	case []*toml.Tree:
+		n.Kind, n.Tag = yaml.SequenceNode, "!!seq"
+		for _, t := range v {
+			n.Content = append(n.Content, tomlTable(t))
+		}
+	case []interface{}:
+		n.Kind, n.Tag = yaml.SequenceNode, "!!seq"
+		for _, elem := range v {
+			n.Content = append(n.Content, tomlValue(elem, pos))
+		}
+	case string:
+		n.Tag, n.Value = "!!str", v
+	case bool:
+		n.Tag, n.Value = "!!bool", strconv.FormatBool(v)
+	case int64:
+		n.Tag, n.Value = "!!int", strconv.FormatInt(v, 10)
+	case uint64:
+		n.Tag, n.Value = "!!int", strconv.FormatUint(v, 10)
+	case float64:
+		n.Tag = "!!float"
+		switch {
+		case math.IsNaN(v):
+			n.Value = ".nan"
+		case math.IsInf(v, 1):
+			n.Value = ".inf"
+		case math.IsInf(v, -1):
+			n.Value = "-.inf"
+		default:
+			n.Value = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+	case time.Time:
+		n.Tag, n.Value = "!!timestamp", v.Format(time.RFC3339Nano)
+	default:
+		n.Tag, n.Value = "!!str", fmt.Sprint(v)
+	}
+	return n
+}
+
+//parseJSONC turns JSON with comments into nodes.
+func parseJSONC(file string, content []byte) (*yaml.Node, error) {
+	clean, err := cleanJSONC(file, content)
+	if err != nil {
+		return nil, err
+	}
+	r := &jsonReader{clean: clean, dec: json.NewDecoder(bytes.NewReader(clean)), lines: []int{0},
+		comments: make(map[int]string)}
+	r.dec.UseNumber()
+	for i, c := range clean {
+		if c == '\n' {
+			r.lines = append(r.lines, i+1)
+		}
+	}
+	for i, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(string(clean[r.lines[i]:r.lines[i]+len(line)])) != "" {
+			continue
+		}
+		line = strings.TrimSpace(line)
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(line, "//"), "/*"))
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSuffix(line, "*/"), "*"))
+		if line != "" {
+			r.comments[i+1] = line
+		}
+	}
+	n, err := r.value()
+	if err != nil {
+		if e, ok := err.(*json.SyntaxError); ok {
+			return nil, position(file, content, e.Offset-1, e.Error())
+		}
+		return nil, position(file, content, r.dec.InputOffset(), err.Error())
+	}
+	return n, nil
+}
+
+//jsonReader reads the tokens of JSON into nodes that say where they came from.  lines
+//has the offset of the start of each line, and comments the text of the lines that are
+//only a comment, which go with the node on the line after them.
+type jsonReader struct {
+	clean    []byte
+	dec      *json.Decoder
+	lines    []int
+	comments map[int]string
+}
+
+//next reads the next token and makes a node at its place for it.
+func (r *jsonReader) next() (json.Token, *yaml.Node, error) {
+	start := int(r.dec.InputOffset())
+	for start < len(r.clean) && strings.IndexByte(" \t\r\n,:", r.clean[start]) != -1 {
+		start++
+	}
+	tok, err := r.dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	line := sort.SearchInts(r.lines, start+1)
+	n := &yaml.Node{Kind: yaml.ScalarNode, Line: line, Column: start - r.lines[line-1] + 1}
+	above := []string{}
+	for l := line - 1; r.comments[l] != ""; l-- {
+		above = append([]string{r.comments[l]}, above...)
+		delete(r.comments, l)
+	}
+	n.HeadComment = strings.Join(above, "\n")
+	return tok, n, nil
+}
+
+//value reads the next value.
+func (r *jsonReader) value() (*yaml.Node, error) {
+	tok, n, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		n.Kind, n.Tag = yaml.SequenceNode, "!!seq"
+		if tok == '{' {
+			n.Kind, n.Tag = yaml.MappingNode, "!!map"
+		}
+		for r.dec.More() {
+			if n.Kind == yaml.MappingNode {
+				key, k, err := r.next()
+				if err != nil {
+					return nil, err
+				}
+				k.Tag, k.Value = "!!str", key.(string)
+				n.Content = append(n.Content, k)
+			}
+			elem, err := r.value()
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, elem)
+		}
+		if _, _, err := r.next(); err != nil {
+			return nil, err
+		}
+	case string:
+		n.Tag, n.Value = "!!str", tok
+	case bool:
+		n.Tag, n.Value = "!!bool", strconv.FormatBool(tok)
+	case json.Number:
+		n.Tag, n.Value = "!!float", tok.String()
+		if _, err := tok.Int64(); err == nil {
+			n.Tag = "!!int"
+		}
+	default:
+		n.Tag, n.Value = "!!null", "null"
+	}
+	return n, nil
+}
+
+//unalias follows aliases to the node they stand for.
+func unalias(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	return n
+}
+
+//mappingPairs returns the keys and values of the mapping n.  Those brought in by YAML
+//merge keys (<<) are included, unless n has the same key itself.
+func mappingPairs(n *yaml.Node) [][2]*yaml.Node {
+	own := [][2]*yaml.Node{}
+	merged := [][2]*yaml.Node{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], unalias(n.Content[i+1])
+		if k.Tag != "!!merge" {
+			own = append(own, [2]*yaml.Node{k, v})
+			continue
+		}
+		sources := []*yaml.Node{v}
+		if v.Kind == yaml.SequenceNode {
+			sources = v.Content
+		}
+		for _, s := range sources {
+			if s = unalias(s); s.Kind == yaml.MappingNode {
+				merged = append(merged, mappingPairs(s)...)
+			}
+		}
+	}
+	seen := make(map[string]bool)
+	for _, pair := range own {
+		seen[pair[0].Value] = true
+	}
+	result := [][2]*yaml.Node{}
+	for _, pair := range merged {
+		if !seen[pair[0].Value] {
+			seen[pair[0].Value] = true
+			result = append(result, pair)
+		}
+	}
+	return append(result, own...)
+}
+
+//place is where in the original files the JSON at offset came from.
+type place struct {
+	offset       int64
+	file         string
+	line, column int
+}
+
+//jsonWriter writes nodes as JSON, remembering where each of them came from.  file is
+//the file of the node being written.
+type jsonWriter struct {
+	file   string
+	from   sources
+	buf    bytes.Buffer
+	places []place
+}
+
+//at is the positioner for the JSON written.  An offset belongs to the last node that
+//started before it.
+func (w *jsonWriter) at(offset int64, msg string) *ConfigError {
+	i := sort.Search(len(w.places), func(i int) bool { return w.places[i].offset > offset }) - 1
+	if i < 0 {
+		return &ConfigError{File: w.file, Line: 1, Msg: msg}
+	}
+	p := w.places[i]
+	return &ConfigError{File: p.file, Line: p.line, Column: p.column, Msg: msg}
+}
+
+func (w *jsonWriter) mark(n *yaml.Node) {
+	w.places = append(w.places, place{offset: int64(w.buf.Len()), file: w.from.file(n, w.file), line: n.Line, column: n.Column})
+}
+
+func (w *jsonWriter) write(n *yaml.Node) error {
+	if file := w.from.file(n, w.file); file != w.file {
+		outer := w.file
+		w.file = file
+		defer func() { w.file = outer }()
+	}
+	w.mark(n)
+	switch n = unalias(n); n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			w.buf.WriteString("null")
+			return nil
+		}
+		return w.write(n.Content[0])
+	case yaml.MappingNode:
+		w.buf.WriteByte('{')
+		for i, pair := range mappingPairs(n) {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			if pair[0].Kind != yaml.ScalarNode {
+				return &ConfigError{File: w.file, Line: pair[0].Line, Column: pair[0].Column, Msg: "keys must be strings"}
+			}
+			w.mark(pair[0])
+			key, _ := json.Marshal(pair[0].Value)
+			w.buf.Write(key)
+			w.buf.WriteByte(':')
+			if err := w.write(pair[1]); err != nil {
+				return err
+			}
+		}
+		w.buf.WriteByte('}')
+	case yaml.SequenceNode:
+		w.buf.WriteByte('[')
+		for i, elem := range n.Content {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			if err := w.write(elem); err != nil {
+				return err
+			}
+		}
+		w.buf.WriteByte(']')
+	default:
+		var v interface{}
+		err := n.Decode(&v)
+		var value []byte
+		if err == nil {
+			value, err = json.Marshal(v)
+		}
+		if err != nil {
+			return &ConfigError{File: w.file, Line: n.Line, Column: n.Column, Msg: fmt.Sprintf("can't use %s: %v", n.Value, err)}
+		}
+		w.buf.Write(value)
+	}
+	return nil
+}
+
+// ConvertConfig translates the configuration read from reader into the format to.  The
+// configuration is decoded as NewConfig would, so one with mistakes in it is not
+// converted.  Comments on lines of their own are kept in YAML and TOML, JSON written
+// here has none.
+func ConvertConfig(reader io.Reader, to string) ([]byte, error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not read all of configuration file: %v", err)
+	}
+	doc, file, err := parseConfig(readerName(reader, ""), content)
+	if err != nil {
+		return nil, err
+	}
+	warnings, err := decodeDocument(file, doc, nil, &Config{})
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range warnings {
+		flog.Warningf("%s", w)
+	}
+	switch to {
+	case FORMAT_JSON:
+		w := &jsonWriter{file: file}
+		if err := w.write(doc); err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		if err := json.Indent(&out, w.buf.Bytes(), "", "\t"); err != nil {
+			return nil, err
+		}
+		out.WriteByte('\n')
+		return out.Bytes(), nil
+	case FORMAT_YAML:
+		var out bytes.Buffer
+		enc := yaml.NewEncoder(&out)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	case FORMAT_TOML:
+		w := &tomlWriter{}
+		w.comment(doc.HeadComment)
+		if err := w.table(nil, doc); err != nil {
+			return nil, err
+		}
+		return w.buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown format %s, expected one of %s, %s or %s", to, FORMAT_JSON, FORMAT_YAML, FORMAT_TOML)
+}
+
+//tomlWriter writes nodes as TOML.
+type tomlWriter struct {
+	buf bytes.Buffer
+}
+
+//bareKey is a key that TOML doesn't need quoted.
+var bareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func tomlKey(key string) string {
+	if bareKey.MatchString(key) {
+		return key
+	}
+	quoted, _ := json.Marshal(key)
+	return string(quoted)
+}
+
+func tomlPath(path []string) string {
+	keys := make([]string, len(path))
+	for i, key := range path {
+		keys[i] = tomlKey(key)
+	}
+	return strings.Join(keys, ".")
+}
+
+//isTables is true if n is a non-empty list of non-empty mappings, which are written as
+//an array of tables.
+func isTables(n *yaml.Node) bool {
+	if n.Kind != yaml.SequenceNode || len(n.Content) == 0 {
+		return false
+	}
+	for _, elem := range n.Content {
+		if elem = unalias(elem); elem.Kind != yaml.MappingNode || len(elem.Content) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//table writes the mapping n, which is at path.  Its plain keys come first, since in
+//TOML everything after a table header belongs to that table.  Keys whose value is null
+//are left out, TOML has no way to say it.
+func (w *tomlWriter) table(path []string, n *yaml.Node) error {
+	var tables, arrays [][2]*yaml.Node
+	for _, pair := range mappingPairs(n) {
+		v := pair[1]
+		switch {
+		case v.Kind == yaml.MappingNode && len(v.Content) > 0:
+			tables = append(tables, pair)
+		case isTables(v):
+			arrays = append(arrays, pair)
+		case v.Kind == yaml.ScalarNode && v.ShortTag() == "!!null":
+		default:
+			value, err := w.inline(v)
+			if err != nil {
+				return err
+			}
+			w.comment(pair[0].HeadComment)
+			fmt.Fprintf(&w.buf, "%s = %s\n", tomlKey(pair[0].Value), value)
+		}
+	}
+	for _, pair := range tables {
+		sub := append(append([]string{}, path...), pair[0].Value)
+		w.buf.WriteString("\n")
+		w.comment(pair[0].HeadComment)
+		fmt.Fprintf(&w.buf, "[%s]\n", tomlPath(sub))
+		if err := w.table(sub, pair[1]); err != nil {
+			return err
+		}
+	}
+	for _, pair := range arrays {
+		sub := append(append([]string{}, path...), pair[0].Value)
+		for i, elem := range pair[1].Content {
+			w.buf.WriteString("\n")
+			if i == 0 {
+				w.comment(pair[0].HeadComment)
+			}
+			w.comment(elem.HeadComment)
+			fmt.Fprintf(&w.buf, "[[%s]]\n", tomlPath(sub))
+			if err := w.table(sub, unalias(elem)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//comment writes text, which is some lines of comment, before what is written next.
+func (w *tomlWriter) comment(text string) {
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			line = "# " + line
+		}
+		w.buf.WriteString(line + "\n")
+	}
+}
+
+//inline returns n written as a TOML value on one line.
+func (w *tomlWriter) inline(n *yaml.Node) (string, error) {
+	switch n = unalias(n); n.Kind {
+	case yaml.MappingNode:
+		parts := []string{}
+		for _, pair := range mappingPairs(n) {
+			if pair[1].ShortTag() == "!!null" {
+				continue
+			}
+			value, err := w.inline(pair[1])
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, tomlKey(pair[0].Value)+" = "+value)
+		}
+		if len(parts) == 0 {
+			return "{}", nil
+		}
+		return "{ " + strings.Join(parts, ", ") + " }", nil
+	case yaml.SequenceNode:
+		parts := []string{}
+		for _, elem := range n.Content {
+			value, err := w.inline(elem)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, value)
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	}
+	var v interface{}
+	if err := n.Decode(&v); err != nil {
+		return "", fmt.Errorf("can't convert %s: %v", n.Value, err)
+	}
+	switch v := v.(type) {
+	case nil:
+		return "", fmt.Errorf("TOML has no null, for the value at line %d", n.Line)
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "nan", nil
+		case math.IsInf(v, 1):
+			return "inf", nil
+		case math.IsInf(v, -1):
+			return "-inf", nil
+		}
+	}
+	value, err := json.Marshal(v)
+	return string(value), err
+}
@@ -3,6 +3,7 @@ package pickett
 import (
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/igneous-systems/pickett/io"
@@ -18,8 +19,23 @@ type goBuilder struct {
 	testFile   string
 	command    string
 	probe      string
+	digest     string
+	moduleDir  string
+	outputs    map[string]string
+	outputCmd  string
+	env        []string
+	parallel   bool
+	caches     []*cacheMount
+	image      *io.ImageConfig
 }
 
+//MAX_CONCURRENT_PROBES is the most probe containers a parallel go build runs at once.
+const MAX_CONCURRENT_PROBES = 8
+
+//GO_MODULE_BIN_DIR is where go install puts the programs of a module, in the image rather
+//than in the module directory, which is on the code volume.
+const GO_MODULE_BIN_DIR = "/go/bin"
+
 func (g *goBuilder) tag() string {
 	return g.repository + ":" + g.tagname
 }
@@ -42,6 +58,22 @@ func (g *goBuilder) ood(conf *Config) (time.Time, bool, error) {
 		return time.Time{}, true, nil
 	}
 
+	//With modules, go.mod, go.sum and all the packages of the module are below
+	//the module directory, so that is all we need to look at.
+	if g.moduleDir != "" {
+		sdc := NewSourceDirChecker(t)
+		laterTime, err := sdc.Check(conf, g.moduleDir)
+		if err != nil {
+			return time.Time{}, true, err
+		}
+		if !laterTime.IsZero() {
+			flog.Infof("Building %s, out of date with respect to module source in %s.", g.tag(), g.moduleDir)
+			return laterTime, true, nil
+		}
+		flog.Infof("'%s' is up to date with respect to module source in %s.", g.tag(), g.moduleDir)
+		return t, false, nil
+	}
+
 	//This is here to support godeps.
 	if g.testFile != "" {
 		f, err := conf.helper.OpenFileRelative(g.testFile)
@@ -67,26 +99,13 @@ func (g *goBuilder) ood(conf *Config) (time.Time, bool, error) {
 	if err != nil {
 		return time.Time{}, true, err
 	}
+	if g.parallel {
+		return g.probeConcurrently(conf, t, runConfig, sequence)
+	}
 	for i, seq := range sequence {
-		if seq[0] == "sourceDirChecker" {
-			sdc := NewSourceDirChecker(t)
-			laterTime, err := sdc.Check(conf, seq[1])
-			if err != nil {
-				return time.Time{}, true, nil
-			}
-			if !laterTime.IsZero() {
-				return laterTime, true, nil
-			}
-		} else {
-			//fire for range
-			buf, _, err := conf.cli.CmdRun(runConfig, seq...)
-			if err != nil {
-				return time.Time{}, true, err
-			}
-			if buf.Len() != 0 {
-				flog.Infof("Building %s, out of date with respect to source in %s.", g.tag(), g.pkgs[i])
-				return time.Time{}, true, nil
-			}
+		laterTime, ood, err := g.runProbe(conf, t, runConfig, seq, g.pkgs[i])
+		if err != nil || ood {
+			return laterTime, ood, err
 		}
 	}
 
@@ -94,6 +113,129 @@ func (g *goBuilder) ood(conf *Config) (time.Time, bool, error) {
 	return t, false, nil
 }
 
+//runProbe runs one probe of the source code of pkg, either by looking at the times in its
+//directory or by running the probe command and looking for output.  It returns true if
+//the package is out of date with respect to t.
+func (g *goBuilder) runProbe(conf *Config, t time.Time, runConfig *io.RunConfig, seq runCommand, pkg string) (time.Time, bool, error) {
+	if seq[0] == "sourceDirChecker" {
+		sdc := NewSourceDirChecker(t)
+		laterTime, err := sdc.Check(conf, seq[1])
+		if err != nil {
+			return time.Time{}, true, nil
+		}
+		if !laterTime.IsZero() {
+			return laterTime, true, nil
+		}
+		return time.Time{}, false, nil
+	}
+	//fire for range
+	buf, _, err := conf.cli.CmdRun(runConfig, seq...)
+	if err != nil {
+		return time.Time{}, true, err
+	}
+	if buf.Len() != 0 {
+		flog.Infof("Building %s, out of date with respect to source in %s.", g.tag(), pkg)
+		return time.Time{}, true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+//probeConcurrently runs all the probes at once (up to MAX_CONCURRENT_PROBES at a time)
+//and merges the results as though they had been run in order.
+func (g *goBuilder) probeConcurrently(conf *Config, t time.Time, runConfig *io.RunConfig, sequence []runCommand) (time.Time, bool, error) {
+	type probeResult struct {
+		laterTime time.Time
+		ood       bool
+		err       error
+	}
+	results := make([]probeResult, len(sequence))
+	limit := make(chan struct{}, MAX_CONCURRENT_PROBES)
+	var wg sync.WaitGroup
+	for i, seq := range sequence {
+		wg.Add(1)
+		go func(i int, seq runCommand) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			r := &results[i]
+			r.laterTime, r.ood, r.err = g.runProbe(conf, t, runConfig, seq, g.pkgs[i])
+		}(i, seq)
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		if r.err != nil || r.ood {
+			return r.laterTime, r.ood, r.err
+		}
+	}
+	flog.Infof("'%s' is up to date with respect to its source code.", g.tag())
+	return t, false, nil
+}
+
+//useGoModule sets up runConfig to run the go tools in module mode, in moduleDir (which is
+//relative to the configuration file).  The module cache is one of the caches.
+func (c *Config) useGoModule(runConfig *io.RunConfig, moduleDir string) error {
+	dir, err := c.containerPath(c.helper.DirectoryRelative(moduleDir))
+	if err != nil {
+		return err
+	}
+	runConfig.WorkingDir = dir
+	runConfig.Env = append(runConfig.Env,
+		"GO111MODULE=on",
+		"GOFLAGS=-modcacherw",
+		"GOBIN="+GO_MODULE_BIN_DIR,
+	)
+	return nil
+}
+
+//platform is a target of cross compilation, given in the configuration as
+//os/arch or os/arm/vN.
+type platform struct {
+	goos   string
+	goarch string
+	goarm  string
+}
+
+func parsePlatform(s string) (*platform, error) {
+	parts := strings.Split(strings.Trim(s, " \n"), "/")
+	for _, p := range parts {
+		if p == "" {
+			return nil, fmt.Errorf("bad platform %s, should be os/arch", s)
+		}
+	}
+	switch {
+	case len(parts) == 2:
+		return &platform{goos: parts[0], goarch: parts[1]}, nil
+	case len(parts) == 3 && parts[1] == "arm" && strings.HasPrefix(parts[2], "v"):
+		return &platform{goos: parts[0], goarch: parts[1], goarm: parts[2][1:]}, nil
+	}
+	return nil, fmt.Errorf("bad platform %s, should be os/arch", s)
+}
+
+//env returns the environment that makes the go tools build for this platform.
+func (p *platform) env() []string {
+	result := []string{"GOOS=" + p.goos, "GOARCH=" + p.goarch}
+	if p.goarm != "" {
+		result = append(result, "GOARM="+p.goarm)
+	}
+	return result
+}
+
+//expand replaces {GOOS}, {GOARCH} and {GOARM} in s.  If there were none and suffix
+//is true, the platform is added at the end instead, like "tag-linux-arm64".
+func (p *platform) expand(s string, suffix bool) string {
+	r := strings.NewReplacer("{GOOS}", p.goos, "{GOARCH}", p.goarch, "{GOARM}", p.goarm)
+	result := r.Replace(s)
+	if result != s || !suffix {
+		return result
+	}
+	result = s + "-" + p.goos + "-" + p.goarch
+	if p.goarm != "" {
+		result += "-v" + p.goarm
+	}
+	return result
+}
+
 type runCommand []string
 
 //formBuildCommand is a helper for forming the sequence of build-related commands to
@@ -107,7 +249,7 @@ func (g *goBuilder) formBuildCommand(conf *Config, dontExecute bool) (*io.RunCon
 		attach = false
 	}
 
-	volumes, err := conf.codeVolumes()
+	volumes, err := conf.codeVolumes(g.runIn.name())
 	if err != nil {
 		return nil, nil, err
 	}
@@ -117,6 +259,7 @@ func (g *goBuilder) formBuildCommand(conf *Config, dontExecute bool) (*io.RunCon
 		WaitOutput: waitOutput,
 		Volumes:    volumes,
 		Image:      g.runIn.name(),
+		Labels:     conf.labels(g.tag(), GOBUILD_NODE, "", 0, g.digest),
 	}
 
 	var baseCmd []string
@@ -125,10 +268,39 @@ func (g *goBuilder) formBuildCommand(conf *Config, dontExecute bool) (*io.RunCon
 	} else {
 		baseCmd = strings.Split(strings.Trim(g.command, " \n"), " ")
 	}
+
+	if g.moduleDir != "" {
+		if err := conf.useGoModule(resultConfig, g.moduleDir); err != nil {
+			return nil, nil, fmt.Errorf("module of go build %s: %v", g.tag(), err)
+		}
+	}
+	resultConfig.Env = append(resultConfig.Env, g.env...)
+	mountCaches(resultConfig, g.caches)
+
 	sequence := []runCommand{}
+	if g.parallel && !dontExecute {
+		//one invocation for everything, the go tool builds the packages in parallel;
+		//only packages with their own output need a command of their own
+		combined := runCommand(append([]string{}, baseCmd...))
+		for _, p := range g.pkgs {
+			if _, ok := g.outputs[p]; !ok {
+				combined = append(combined, p)
+			}
+		}
+		if len(combined) > len(baseCmd) {
+			sequence = append(sequence, combined)
+		}
+	}
 	for _, p := range g.pkgs {
-		rc := runCommand(append(baseCmd, p))
-		sequence = append(sequence, rc)
+		out, ok := g.outputs[p]
+		if g.parallel && !dontExecute && !ok {
+			continue
+		}
+		rc := runCommand(append([]string{}, baseCmd...))
+		if ok {
+			rc = append(strings.Split(strings.Trim(g.outputCmd, " \n"), " "), "-o", out)
+		}
+		sequence = append(sequence, append(rc, p))
 	}
 
 	return resultConfig, sequence, nil
@@ -142,6 +314,10 @@ func (g *goBuilder) build(conf *Config) (time.Time, error) {
 		return time.Time{}, err
 	}
 	img := runConfig.Image
+	commitConf, err := commitConfig(conf, img, runConfig)
+	if err != nil {
+		return time.Time{}, err
+	}
 
 	for _, seq := range sequence {
 		runConfig.Image = img
@@ -150,14 +326,20 @@ func (g *goBuilder) build(conf *Config) (time.Time, error) {
 			return time.Time{}, err
 		}
 		//update the image
-		img, err = conf.cli.CmdCommit(contId, nil)
+		img, err = conf.cli.CmdCommit(contId, nil, commitConf)
 		if err != nil {
 			return time.Time{}, err
 		}
 	}
 
-	//command was ok, we need to tag it now
-	err = conf.cli.CmdTag(img, true, &io.TagInfo{g.repository, g.tagname})
+	//command was ok, we need to tag it now; if the image has settings of its own, they
+	//are applied by committing one more container that has them
+	if g.image != nil {
+		info := &io.TagInfo{Repository: g.repository, Tag: g.tagname}
+		err = conf.cli.CmdCopy(map[string]string{}, "", img, nil, info, withLabels(g.image, runConfig.Labels))
+	} else {
+		err = conf.cli.CmdTag(img, true, &io.TagInfo{g.repository, g.tagname})
+	}
 	if err != nil {
 		return time.Time{}, fmt.Errorf("failed trying to commit (%s): %v", g.tag(), err)
 	}
@@ -168,6 +350,37 @@ func (g *goBuilder) build(conf *Config) (time.Time, error) {
 	return insp.CreatedTime(), nil
 }
 
+//commitConfig returns the settings to commit a build container that ran in the image
+//runIn with, so the result has the environment and working directory of runIn rather
+//than the ones of the build.  Docker fills in what the settings leave out from the
+//container and keeps the variables they don't name, so each variable that only the
+//build had is given an empty value, which the go tools take as unset.
+func commitConfig(conf *Config, runIn string, runConfig *io.RunConfig) (*io.ImageConfig, error) {
+	insp, err := conf.cli.InspectImage(runIn)
+	if err != nil {
+		return nil, fmt.Errorf("can't inspect %s to commit a build in it: %v", runIn, err)
+	}
+	result := &io.ImageConfig{
+		Labels:     runConfig.Labels,
+		Env:        append([]string{}, insp.Env()...),
+		WorkingDir: insp.WorkingDir(),
+	}
+	if result.WorkingDir == "" {
+		result.WorkingDir = "/"
+	}
+	named := make(map[string]bool)
+	for _, v := range result.Env {
+		named[strings.SplitN(v, "=", 2)[0]] = true
+	}
+	for _, v := range runConfig.Env {
+		if name := strings.SplitN(v, "=", 2)[0]; !named[name] {
+			named[name] = true
+			result.Env = append(result.Env, name+"=")
+		}
+	}
+	return result, nil
+}
+
 func (g *goBuilder) in() []node {
 	return []node{
 		g.runIn,
@@ -16,6 +16,8 @@ import (
 func setupForDontBuildBletch(controller *gomock.Controller, helper *io.MockHelper,
 	cli *io.MockDockerCli, etcd *io.MockEtcdClient) *Config {
 	setupForExample1Conf(controller, helper)
+	setupForLabels(helper)
+	setupForUserConfig(helper)
 	//ignoring error is ok because tested in TestConf
 	c, _ := NewConfig(strings.NewReader(example1), helper, cli, etcd)
 
@@ -27,6 +29,12 @@ func setupForDontBuildBletch(controller *gomock.Controller, helper *io.MockHelpe
 	insp.EXPECT().CreatedTime().Return(now)
 	cli.EXPECT().InspectImage("blah:bletch").Return(insp, nil)
 
+	//builds in bletch are committed with its settings
+	settings := io.NewMockInspectedImage(controller)
+	settings.EXPECT().Env().Return([]string{"PATH=/usr/bin"}).AnyTimes()
+	settings.EXPECT().WorkingDir().Return("").AnyTimes()
+	cli.EXPECT().InspectImage("blah:bletch").Return(settings, nil).AnyTimes()
+
 	return c
 }
 
@@ -54,7 +62,7 @@ func TestGoPackagesFailOnBuildStep2(t *testing.T) {
 	cli.EXPECT().CmdRun(gomock.Any(), "go", "install", "p5/p6").Return(nil, "", fakeErr).After(first)
 
 	//one commits, one for each successful build
-	cli.EXPECT().CmdCommit("some_cont", nil)
+	cli.EXPECT().CmdCommit("some_cont", nil, gomock.Any())
 
 	if err := c.Build("fart:chattanooga"); err != fakeErr {
 		t.Errorf("failed to get expected error: %v", err)
@@ -90,8 +98,8 @@ func TestGoPackagesAllBuilt(t *testing.T) {
 	cli.EXPECT().CmdRun(gomock.Any(), "go", "test", "p1...").Return(nil, "bah", nil)
 	cli.EXPECT().CmdRun(gomock.Any(), "go", "test", "p2/p3").Return(nil, "humbug", nil)
 
-	cli.EXPECT().CmdCommit("bah", nil)
-	cli.EXPECT().CmdCommit("humbug", nil).Return("imagehumbug", nil)
+	cli.EXPECT().CmdCommit("bah", nil, gomock.Any())
+	cli.EXPECT().CmdCommit("humbug", nil, gomock.Any()).Return("imagehumbug", nil)
 	cli.EXPECT().CmdTag("imagehumbug", true, &io.TagInfo{"test", "nashville"})
 
 	//hit it!
@@ -159,10 +167,215 @@ func TestGoPackagesOODOnSource(t *testing.T) {
 	//after we build successfully, we use "ps -q -l" to check to see the id of
 	//the container that we built in.
 	//expectContainerPSAndCommit(cli)
-	cli.EXPECT().CmdCommit("cont1", nil).Return("someid", nil)
-	cli.EXPECT().CmdCommit("cont2", nil).Return("someotherid", nil)
+	cli.EXPECT().CmdCommit("cont1", nil, gomock.Any()).Return("someid", nil)
+	cli.EXPECT().CmdCommit("cont2", nil, gomock.Any()).Return("someotherid", nil)
 	cli.EXPECT().CmdTag("someotherid", true, &io.TagInfo{"test", "nashville"})
 	//hit it!
 	c.Build("test:nashville")
 
 }
+
+var moduleExample = `
+{
+	"CodeVolumes" : [
+		{ "Directory" : "src", "MountedAt" : "/han" }
+	],
+	"Containers" : [
+		{ "Repository": "golang", "Tag" : "1.16", "Directory" : "godir" }
+	],
+	"GoBuilds" : [
+		{
+			"Repository": "test",
+			"RunIn" : "golang:1.16",
+			"ModuleDir" : "src/falcon",
+			"Packages": ["./cmd/falcon", "./..." ],
+			"Outputs" : { "./cmd/falcon" : "/usr/local/bin/falcon" },
+			"Tag": "memphis"
+		}
+	]
+}
+`
+
+func TestGoModulesOODOnModuleSource(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	helper := io.NewMockHelper(controller)
+
+	setupForLabels(helper)
+	setupForUserConfig(helper)
+	helper.EXPECT().OpenDockerfileRelative("godir").Return(nil, nil)
+
+	c, err := NewConfig(strings.NewReader(moduleExample), helper, cli, nil)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+
+	helper.EXPECT().DirectoryRelative("src").Return("/home/gredo/src").AnyTimes()
+	helper.EXPECT().DirectoryRelative("src/falcon").Return("/home/gredo/src/falcon").AnyTimes()
+
+	//the image we build in is up to date
+	hourAgo := time.Now().Add(-1 * time.Hour)
+	helper.EXPECT().LastTimeInDirRelative("godir").Return(hourAgo.Add(-1*time.Hour), nil)
+	runIn := io.NewMockInspectedImage(controller)
+	runIn.EXPECT().CreatedTime().Return(hourAgo).AnyTimes()
+	runIn.EXPECT().Env().Return([]string{"PATH=/usr/local/go/bin", "GOPATH=/go"}).AnyTimes()
+	runIn.EXPECT().WorkingDir().Return("/go").AnyTimes()
+	cli.EXPECT().InspectImage("golang:1.16").Return(runIn, nil).AnyTimes()
+
+	//built half an hour ago, but the module source changed since
+	built := io.NewMockInspectedImage(controller)
+	built.EXPECT().CreatedTime().Return(time.Now().Add(-30 * time.Minute)).AnyTimes()
+	cli.EXPECT().InspectImage("test:memphis").Return(built, nil).AnyTimes()
+	helper.EXPECT().LastTimeInDirRelative("src/falcon").Return(time.Now(), nil)
+
+	var runConfig *io.RunConfig
+	first := cli.EXPECT().CmdRun(gomock.Any(), "go", "build", "-o", "/usr/local/bin/falcon", "./cmd/falcon").Do(
+		func(rc *io.RunConfig, s ...string) { runConfig = rc }).Return(nil, "cont1", nil)
+	//what has no output is installed in the image, not written to the module directory
+	cli.EXPECT().CmdRun(gomock.Any(), "go", "install", "./...").Return(nil, "cont2", nil).After(first)
+	var commitConf *io.ImageConfig
+	cli.EXPECT().CmdCommit("cont1", nil, gomock.Any()).Do(
+		func(cont string, info *io.TagInfo, ic *io.ImageConfig) { commitConf = ic }).Return("someid", nil)
+	cli.EXPECT().CmdCommit("cont2", nil, gomock.Any()).Return("someotherid", nil)
+	cli.EXPECT().CmdTag("someotherid", true, &io.TagInfo{Repository: "test", Tag: "memphis"})
+
+	if err := c.Build("test:memphis"); err != nil {
+		t.Fatalf("unexpected error building: %v", err)
+	}
+	if runConfig.WorkingDir != "/han/falcon" {
+		t.Errorf("expected build in module directory but got %s", runConfig.WorkingDir)
+	}
+	if !contains(runConfig.Env, "GOBIN="+GO_MODULE_BIN_DIR) {
+		t.Errorf("expected go install to put programs in %s: %v", GO_MODULE_BIN_DIR, runConfig.Env)
+	}
+	if runConfig.Volumes[CACHE_VOLUME_PREFIX+GO_MODULE_CACHE_KEY] != CACHE_DIR+"/gomodcache" || !contains(runConfig.Env, "GOMODCACHE="+CACHE_DIR+"/gomodcache") {
+		t.Errorf("expected module cache volume to be mounted: %v %v", runConfig.Volumes, runConfig.Env)
+	}
+	//the image has the settings of golang:1.16, not the ones of the build
+	env := strings.Join(commitConf.Env, " ")
+	if env != "PATH=/usr/local/go/bin GOPATH=/go GO111MODULE= GOFLAGS= GOBIN= GOMODCACHE=" || commitConf.WorkingDir != "/go" {
+		t.Errorf("expected the build's settings to be left out of the image: %+v", commitConf)
+	}
+}
+
+func TestGoModulesBadOutputs(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	helper := io.NewMockHelper(controller)
+
+	helper.EXPECT().OpenDockerfileRelative("godir").Return(nil, nil)
+
+	bad := strings.Replace(moduleExample, `"./cmd/falcon" : "/usr`, `"./cmd/hawk" : "/usr`, 1)
+	if _, err := NewConfig(strings.NewReader(bad), helper, cli, nil); err == nil {
+		t.Errorf("expected error for an output of a package that is not built")
+	}
+}
+
+func TestGoBuildPlatforms(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	helper := io.NewMockHelper(controller)
+
+	setupForLabels(helper)
+	setupForUserConfig(helper)
+	helper.EXPECT().OpenDockerfileRelative("godir").Return(nil, nil)
+
+	multi := strings.Replace(moduleExample, `"Tag": "memphis"`, `"Tag": "memphis", "Platforms" : [ "linux/amd64", "linux/arm/v7" ]`, 1)
+	multi = strings.Replace(multi, `"/usr/local/bin/falcon"`, `"/out/{GOOS}_{GOARCH}/falcon"`, 1)
+	c, err := NewConfig(strings.NewReader(multi), helper, cli, nil)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	for _, tag := range []string{"test:memphis-linux-amd64", "test:memphis-linux-arm-v7"} {
+		if _, ok := c.nameToNode[tag]; !ok {
+			t.Errorf("expected a node for %s", tag)
+		}
+	}
+	if _, ok := c.nameToNode["test:memphis"]; ok {
+		t.Errorf("did not expect a node for test:memphis")
+	}
+
+	helper.EXPECT().DirectoryRelative("src").Return("/home/gredo/src").AnyTimes()
+	helper.EXPECT().DirectoryRelative("src/falcon").Return("/home/gredo/src/falcon").AnyTimes()
+	g := c.nameToNode["test:memphis-linux-arm-v7"].(*nodeImpl).b.(*goBuilder)
+	runConfig, sequence, err := g.formBuildCommand(c, false)
+	if err != nil {
+		t.Fatalf("unable to form build command: %v", err)
+	}
+	if !contains(runConfig.Env, "GOOS=linux") || !contains(runConfig.Env, "GOARCH=arm") || !contains(runConfig.Env, "GOARM=7") {
+		t.Errorf("bad environment for platform: %v", runConfig.Env)
+	}
+	if strings.Join(sequence[0], " ") != "go build -o /out/linux_arm/falcon ./cmd/falcon" {
+		t.Errorf("bad build command: %v", sequence[0])
+	}
+
+	//the image built for a platform doesn't cross compile whatever runs in it later
+	runIn := io.NewMockInspectedImage(controller)
+	runIn.EXPECT().Env().Return([]string{"GOOS=linux"})
+	runIn.EXPECT().WorkingDir().Return("/go")
+	cli.EXPECT().InspectImage("golang:1.16").Return(runIn, nil)
+	commitConf, err := commitConfig(c, runConfig.Image, runConfig)
+	if err != nil {
+		t.Fatalf("unable to work out the settings to commit with: %v", err)
+	}
+	if !contains(commitConf.Env, "GOOS=linux") || !contains(commitConf.Env, "GOARCH=") || !contains(commitConf.Env, "GOARM=") || contains(commitConf.Env, "GOARCH=arm") {
+		t.Errorf("expected the platform to be left out of the image: %v", commitConf.Env)
+	}
+
+	bad := strings.Replace(moduleExample, `"Tag": "memphis"`, `"Tag": "memphis", "Platforms" : [ "linux" ]`, 1)
+	helper.EXPECT().OpenDockerfileRelative("godir").Return(nil, nil)
+	if _, err := NewConfig(strings.NewReader(bad), helper, cli, nil); err == nil {
+		t.Errorf("expected error for a platform without an architecture")
+	}
+}
+
+func TestGoPackagesParallel(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	helper := io.NewMockHelper(controller)
+	etcd := io.NewMockEtcdClient(controller)
+
+	setupForExample1Conf(controller, helper)
+	setupForLabels(helper)
+	setupForUserConfig(helper)
+	parallel := strings.Replace(example1, `"Tag": "chattanooga"`, `"Tag": "chattanooga", "Parallel" : true`, 1)
+	c, err := NewConfig(strings.NewReader(parallel), helper, cli, etcd)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+
+	now := time.Now()
+	hourAgo := now.Add(-1 * time.Hour)
+	helper.EXPECT().LastTimeInDirRelative("mydir").Return(hourAgo, nil)
+	bletch := io.NewMockInspectedImage(controller)
+	bletch.EXPECT().CreatedTime().Return(hourAgo).AnyTimes()
+	bletch.EXPECT().Env().Return(nil).AnyTimes()
+	bletch.EXPECT().WorkingDir().Return("").AnyTimes()
+	cli.EXPECT().InspectImage("blah:bletch").Return(bletch, nil).AnyTimes()
+	helper.EXPECT().DirectoryRelative("src").Return("/home/gredo/src").AnyTimes()
+
+	insp := io.NewMockInspectedImage(controller)
+	insp.EXPECT().CreatedTime().Return(now).AnyTimes()
+	cli.EXPECT().InspectImage("fart:chattanooga").Return(insp, nil).AnyTimes()
+
+	//both probes are run, even though the first says we are out of date
+	cli.EXPECT().CmdRun(gomock.Any(), "go", "install", "-n", "p4...").Return(bytes.NewBufferString("stuff"), "", nil)
+	cli.EXPECT().CmdRun(gomock.Any(), "go", "install", "-n", "p5/p6").Return(new(bytes.Buffer), "", nil)
+
+	//then one container builds everything
+	cli.EXPECT().CmdRun(gomock.Any(), "go", "install", "p4...", "p5/p6").Return(nil, "cont", nil)
+	cli.EXPECT().CmdCommit("cont", nil, gomock.Any()).Return("someid", nil)
+	cli.EXPECT().CmdTag("someid", true, &io.TagInfo{Repository: "fart", Tag: "chattanooga"})
+
+	if err := c.Build("fart:chattanooga"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,438 @@
+package pickett
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+//These are the legal values of the Format of a GoTest.
+const (
+	TEST_FORMAT_JSON  = "JSON"
+	TEST_FORMAT_JUNIT = "JUNIT"
+)
+
+//TESTS is where the result of the last run of each test node is kept.
+const TESTS = "tests"
+
+//These are the results of a test node recorded in etcd.
+const (
+	TEST_PASSED = "pass"
+	TEST_FAILED = "fail"
+)
+
+//DEFAULT_REPORT_DIR is where test reports go if the test doesn't say, relative to
+//the configuration file.
+const DEFAULT_REPORT_DIR = "test-reports"
+
+// goTester runs go tests in the image it depends on, with the code volumes mounted.
+// Unlike the other builders, it produces no image, just reports.  This implements the
+// builder interface.
+type goTester struct {
+	name      string
+	runIn     node
+	pkgs      []string
+	moduleDir string
+	command   string
+	format    string
+	reportDir string
+	digest    string
+	caches    []*cacheMount
+}
+
+func (g *goTester) tag() string {
+	return g.name
+}
+
+func (g *goTester) in() []node {
+	return []node{
+		g.runIn,
+	}
+}
+
+//tester returns the go test node called name, if there is one.
+func (c *Config) tester(name string) (*goTester, bool) {
+	n, ok := c.nameToNode[name]
+	if !ok {
+		return nil, false
+	}
+	t, ok := n.implementation().(*goTester)
+	return t, ok
+}
+
+//resultKey is where the result of the last run of this test is kept in etcd.
+func (g *goTester) resultKey() string {
+	return filepath.Join(io.PICKETT_KEYSPACE, TESTS, g.name)
+}
+
+//lastResult returns the time and outcome of the last run of this test, if it was
+//run from the same declaration.  The time is zero if there is no such run.
+func (g *goTester) lastResult(conf *Config) (time.Time, string, error) {
+	value, found, err := conf.etcd.Get(g.resultKey())
+	if err != nil || !found {
+		return time.Time{}, "", err
+	}
+	parts := strings.Fields(value)
+	if len(parts) != 3 || parts[2] != g.digest {
+		return time.Time{}, "", nil
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", nil
+	}
+	return time.Unix(0, nanos), parts[1], nil
+}
+
+//forget removes the result of the last run of this test, if any.
+func (g *goTester) forget(conf *Config) error {
+	_, found, err := conf.etcd.Get(g.resultKey())
+	if err != nil || !found {
+		return err
+	}
+	_, err = conf.etcd.Del(g.resultKey())
+	return err
+}
+
+//sourceDirs returns the directories, relative to the configuration file, that hold
+//the code being tested.
+func (g *goTester) sourceDirs(conf *Config) []string {
+	if g.moduleDir != "" {
+		return []string{g.moduleDir}
+	}
+	result := []string{}
+	for _, v := range conf.CodeVolumes {
+		result = append(result, v.Directory)
+	}
+	return result
+}
+
+// ood is true if the tests have not passed since the last change to the image they
+// run in or to the code being tested.
+func (g *goTester) ood(conf *Config) (time.Time, bool, error) {
+	t, result, err := g.lastResult(conf)
+	if err != nil {
+		return time.Time{}, true, err
+	}
+	if t.IsZero() {
+		flog.Infof("Testing %s, no previous run found.", g.name)
+		return time.Time{}, true, nil
+	}
+	if result != TEST_PASSED {
+		flog.Infof("Testing %s, failed last time.", g.name)
+		return time.Time{}, true, nil
+	}
+	if t.Before(g.runIn.time()) {
+		flog.Infof("Testing %s, out of date with respect to '%s'.", g.name, g.runIn.name())
+		return time.Time{}, true, nil
+	}
+	sdc := NewSourceDirChecker(t)
+	for _, dir := range g.sourceDirs(conf) {
+		laterTime, err := sdc.Check(conf, dir)
+		if err != nil {
+			return time.Time{}, true, err
+		}
+		if !laterTime.IsZero() {
+			flog.Infof("Testing %s, out of date with respect to source in %s.", g.name, dir)
+			return laterTime, true, nil
+		}
+	}
+	flog.Infof("'%s' passed and is up to date with respect to its source code.", g.name)
+	return t, false, nil
+}
+
+//runCommand returns the command that runs the tests.  Test commands that produce
+//JUnit XML are usually pipelines, so they are given to the shell.
+func (g *goTester) runCommand() []string {
+	if g.format == TEST_FORMAT_JUNIT {
+		return []string{"/bin/sh", "-c", g.command}
+	}
+	result := strings.Split(strings.Trim(g.command, " \n"), " ")
+	return append(result, g.pkgs...)
+}
+
+// build runs the tests, writes the reports and records the result.  It fails only if
+// the tests could not be run or did not pass.
+func (g *goTester) build(conf *Config) (time.Time, error) {
+	start := time.Now()
+	image := g.runIn.name()
+	volumes, err := conf.codeVolumes(image)
+	if err != nil {
+		return time.Time{}, err
+	}
+	runConfig := &io.RunConfig{
+		Image:      image,
+		WaitOutput: true,
+		Volumes:    volumes,
+		Labels:     conf.labels(g.name, GOTEST_NODE, "", 0, g.digest),
+	}
+	if g.moduleDir != "" {
+		if err := conf.useGoModule(runConfig, g.moduleDir); err != nil {
+			return time.Time{}, fmt.Errorf("module of go test %s: %v", g.name, err)
+		}
+	}
+	mountCaches(runConfig, g.caches)
+
+	fmt.Printf("[pickett] running tests %s\n", g.name)
+	out, contId, err := conf.cli.CmdRun(runConfig, g.runCommand()...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	insp, err := conf.cli.InspectContainer(contId)
+	if err != nil {
+		return time.Time{}, err
+	}
+	status := insp.ExitStatus()
+	if err := conf.cli.CmdRmContainer(contId); err != nil {
+		flog.Warningf("unable to remove test container %s: %v", contId, err)
+	}
+
+	var output []byte
+	if out != nil {
+		output = out.Bytes()
+	}
+	suites, events, other := parseTestOutput(g.format, output)
+	if err := g.writeReports(conf, suites, events); err != nil {
+		return time.Time{}, err
+	}
+	summary := summarizeSuites(suites)
+	fmt.Printf("[pickett] %s: %s\n", g.name, summary)
+
+	result := TEST_PASSED
+	if status != 0 || len(summary.failed) != 0 {
+		result = TEST_FAILED
+	}
+	value := fmt.Sprintf("%d %s %s", start.UnixNano(), result, g.digest)
+	if _, err := conf.etcd.Put(g.resultKey(), value); err != nil {
+		return time.Time{}, err
+	}
+	if result == TEST_PASSED {
+		return start, nil
+	}
+	for _, name := range summary.failed {
+		fmt.Printf("[pickett] FAIL: %s\n", name)
+	}
+	if len(summary.failed) == 0 && len(other) != 0 {
+		//probably didn't compile, the details aren't in the structured output
+		fmt.Printf("%s", other)
+	}
+	return time.Time{}, fmt.Errorf("tests %s failed (exit code %d)", g.name, status)
+}
+
+//writeReports puts the JUnit XML report and, for go test -json, the json events in
+//the report directory.
+func (g *goTester) writeReports(conf *Config, suites *junitSuites, events []byte) error {
+	report, err := xml.MarshalIndent(suites, "", "\t")
+	if err != nil {
+		return err
+	}
+	report = append([]byte(xml.Header), report...)
+	if err := conf.helper.WriteFileRelative(filepath.Join(g.reportDir, g.name+".xml"), report); err != nil {
+		return fmt.Errorf("unable to write report for %s: %v", g.name, err)
+	}
+	if g.format != TEST_FORMAT_JSON {
+		return nil
+	}
+	if err := conf.helper.WriteFileRelative(filepath.Join(g.reportDir, g.name+".json"), events); err != nil {
+		return fmt.Errorf("unable to write report for %s: %v", g.name, err)
+	}
+	return nil
+}
+
+//
+// Test results.  Everything is turned into JUnit's structure, since that is what the
+// tools that display results want.
+//
+
+type junitSuites struct {
+	XMLName xml.Name      `xml:"testsuites"`
+	Suites  []*junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr,omitempty"`
+	Cases    []*junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr,omitempty"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Body    string `xml:",chardata"`
+}
+
+//testEvent is one line of the output of go test -json.
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+//parseTestOutput turns the output of a test command into JUnit's structure.  It also
+//returns the json events (for go test -json) and the output that was not understood.
+func parseTestOutput(format string, output []byte) (*junitSuites, []byte, []byte) {
+	if format == TEST_FORMAT_JUNIT {
+		return parseJUnit(output)
+	}
+	return parseTestEvents(output)
+}
+
+//parseJUnit finds the JUnit XML in output, which may be a <testsuites> or a single
+//<testsuite>.  Anything before the XML is returned as not understood.
+func parseJUnit(output []byte) (*junitSuites, []byte, []byte) {
+	result := &junitSuites{}
+	start := bytes.Index(output, []byte("<testsuite"))
+	if start == -1 {
+		return result, nil, output
+	}
+	if decl := bytes.LastIndex(output[:start], []byte("<?xml")); decl != -1 {
+		start = decl
+	}
+	doc := output[start:]
+	if err := xml.Unmarshal(doc, result); err == nil {
+		return result, nil, output[:start]
+	}
+	single := &junitSuite{}
+	if err := xml.Unmarshal(doc, single); err != nil {
+		flog.Warningf("unable to understand JUnit output: %v", err)
+		return result, nil, output
+	}
+	result.Suites = []*junitSuite{single}
+	return result, nil, output[:start]
+}
+
+//parseTestEvents reads the output of go test -json.  A package that fails without
+//a failing test (usually because it didn't build) is reported as a failed test case.
+func parseTestEvents(output []byte) (*junitSuites, []byte, []byte) {
+	var events, other bytes.Buffer
+	suites := make(map[string]*junitSuite)
+	testOutput := make(map[string]*bytes.Buffer)
+	failedTest := make(map[string]bool)
+
+	suite := func(pkg string) *junitSuite {
+		s, ok := suites[pkg]
+		if !ok {
+			s = &junitSuite{Name: pkg}
+			suites[pkg] = s
+		}
+		return s
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		ev := &testEvent{}
+		if len(line) == 0 || line[0] != '{' || json.Unmarshal(line, ev) != nil {
+			other.Write(line)
+			other.WriteString("\n")
+			continue
+		}
+		events.Write(line)
+		events.WriteString("\n")
+		if ev.Package == "" {
+			continue
+		}
+		s := suite(ev.Package)
+		key := ev.Package + " " + ev.Test
+		if _, ok := testOutput[key]; !ok {
+			testOutput[key] = new(bytes.Buffer)
+		}
+		switch ev.Action {
+		case "output":
+			testOutput[key].WriteString(ev.Output)
+		case "pass", "fail", "skip":
+			elapsed := strconv.FormatFloat(ev.Elapsed, 'f', 3, 64)
+			if ev.Test == "" {
+				s.Time = elapsed
+				if ev.Action == "fail" && !failedTest[ev.Package] {
+					s.Cases = append(s.Cases, &junitCase{
+						Name:      "[package failed]",
+						Classname: ev.Package,
+						Failure:   &junitMessage{Message: "package failed", Body: testOutput[key].String()},
+					})
+				}
+				continue
+			}
+			c := &junitCase{Name: ev.Test, Classname: ev.Package, Time: elapsed}
+			switch ev.Action {
+			case "fail":
+				c.Failure = &junitMessage{Message: "test failed", Body: testOutput[key].String()}
+				failedTest[ev.Package] = true
+			case "skip":
+				c.Skipped = &junitMessage{Body: testOutput[key].String()}
+			}
+			s.Cases = append(s.Cases, c)
+		}
+	}
+
+	names := []string{}
+	for pkg := range suites {
+		names = append(names, pkg)
+	}
+	sort.Strings(names)
+	result := &junitSuites{}
+	for _, pkg := range names {
+		s := suites[pkg]
+		for _, c := range s.Cases {
+			s.Tests++
+			if c.Failure != nil {
+				s.Failures++
+			}
+			if c.Skipped != nil {
+				s.Skipped++
+			}
+		}
+		result.Suites = append(result.Suites, s)
+	}
+	return result, events.Bytes(), other.Bytes()
+}
+
+//testSummary is what we tell the user about a test run.
+type testSummary struct {
+	passed  int
+	skipped int
+	failed  []string
+}
+
+func (s *testSummary) String() string {
+	return fmt.Sprintf("%d passed, %d failed, %d skipped", s.passed, len(s.failed), s.skipped)
+}
+
+func summarizeSuites(suites *junitSuites) *testSummary {
+	result := &testSummary{}
+	for _, s := range suites.Suites {
+		for _, c := range s.Cases {
+			switch {
+			case c.Failure != nil || c.Error != nil:
+				result.failed = append(result.failed, c.Classname+"."+c.Name)
+			case c.Skipped != nil:
+				result.skipped++
+			default:
+				result.passed++
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,136 @@
+package pickett
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/gomock/gomock"
+	"github.com/igneous-systems/pickett/io"
+)
+
+var testExample = `
+{
+	"CodeVolumes" : [
+		{ "Directory" : "src", "MountedAt" : "/han" }
+	],
+	"Containers" : [
+		{ "Repository": "golang", "Tag" : "1.12", "Directory" : "godir" }
+	],
+	"GoTests" : [
+		{
+			"Name" : "unit",
+			"RunIn" : "golang:1.12",
+			"Packages": ["p1/...", "p2"]
+		}
+	]
+}
+`
+
+var testEvents = `{"Action":"run","Package":"p1","Test":"TestGood"}
+{"Action":"output","Package":"p1","Test":"TestGood","Output":"=== RUN   TestGood\n"}
+{"Action":"pass","Package":"p1","Test":"TestGood","Elapsed":0.01}
+{"Action":"pass","Package":"p1","Elapsed":0.02}
+{"Action":"run","Package":"p2","Test":"TestBad"}
+{"Action":"output","Package":"p2","Test":"TestBad","Output":"    bad_test.go:12: wrong answer\n"}
+{"Action":"fail","Package":"p2","Test":"TestBad","Elapsed":0.5}
+{"Action":"fail","Package":"p2","Elapsed":0.6}
+`
+
+//setupForTestExample parses testExample and makes the image the tests run in up to date.
+func setupForTestExample(t *testing.T, controller *gomock.Controller, helper *io.MockHelper,
+	cli *io.MockDockerCli, etcd *io.MockEtcdClient) *Config {
+	setupForLabels(helper)
+	setupForUserConfig(helper)
+	helper.EXPECT().OpenDockerfileRelative("godir").Return(nil, nil)
+	c, err := NewConfig(strings.NewReader(testExample), helper, cli, etcd)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+
+	hourAgo := time.Now().Add(-1 * time.Hour)
+	helper.EXPECT().LastTimeInDirRelative("godir").Return(hourAgo.Add(-1*time.Hour), nil)
+	insp := io.NewMockInspectedImage(controller)
+	insp.EXPECT().CreatedTime().Return(hourAgo).AnyTimes()
+	cli.EXPECT().InspectImage("golang:1.12").Return(insp, nil).AnyTimes()
+	helper.EXPECT().DirectoryRelative("src").Return("/home/gredo/src").AnyTimes()
+	return c
+}
+
+func TestGoTestReportsFailure(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+	c := setupForTestExample(t, controller, helper, cli, etcd)
+
+	etcd.EXPECT().Get("/pickett/tests/unit").Return("", false, nil)
+	cli.EXPECT().CmdRun(gomock.Any(), "go", "test", "-json", "p1/...", "p2").Return(bytes.NewBufferString(testEvents), "testcont", nil)
+	cont := io.NewMockInspectedContainer(controller)
+	cont.EXPECT().ExitStatus().Return(1)
+	cli.EXPECT().InspectContainer("testcont").Return(cont, nil)
+	cli.EXPECT().CmdRmContainer("testcont").Return(nil)
+
+	var report string
+	helper.EXPECT().WriteFileRelative("test-reports/unit.xml", gomock.Any()).Do(
+		func(path string, content []byte) { report = string(content) }).Return(nil)
+	helper.EXPECT().WriteFileRelative("test-reports/unit.json", gomock.Any()).Return(nil)
+	etcd.EXPECT().Put("/pickett/tests/unit", gomock.Any()).Do(func(key string, value string) {
+		if !strings.Contains(value, " fail ") {
+			t.Errorf("expected failure to be recorded: %s", value)
+		}
+	}).Return("", nil)
+
+	if err := c.Build("unit"); err == nil {
+		t.Errorf("expected build to fail because of the failed test")
+	}
+	if !strings.Contains(report, `<testcase name="TestBad" classname="p2" time="0.500">`) ||
+		!strings.Contains(report, "wrong answer") {
+		t.Errorf("failed test not in report:\n%s", report)
+	}
+}
+
+func TestGoTestNotRerunWhenUpToDate(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+	c := setupForTestExample(t, controller, helper, cli, etcd)
+
+	tester, ok := c.tester("unit")
+	if !ok {
+		t.Fatalf("expected unit to be a go test")
+	}
+	passed := fmt.Sprintf("%d %s %s", time.Now().UnixNano(), TEST_PASSED, tester.digest)
+	etcd.EXPECT().Get("/pickett/tests/unit").Return(passed, true, nil)
+	helper.EXPECT().LastTimeInDirRelative("src").Return(time.Now().Add(-1*time.Minute), nil)
+
+	//no CmdRun expected
+	if err := c.Build("unit"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestParseJUnitSingleSuite(t *testing.T) {
+	output := []byte(`compiling...
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuite name="p3" tests="2" failures="1">
+	<testcase name="TestA" classname="p3"></testcase>
+	<testcase name="TestB" classname="p3"><failure message="oops">boom</failure></testcase>
+</testsuite>
+`)
+	suites, _, other := parseTestOutput(TEST_FORMAT_JUNIT, output)
+	summary := summarizeSuites(suites)
+	if summary.passed != 1 || len(summary.failed) != 1 || summary.failed[0] != "p3.TestB" {
+		t.Errorf("bad summary of JUnit output: %s %v", summary, summary.failed)
+	}
+	if string(other) != "compiling...\n" {
+		t.Errorf("expected the output before the XML to be kept, got %q", other)
+	}
+}
@@ -0,0 +1,100 @@
+package pickett
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// CmdValidate reads nothing but the configuration, which has already been checked by the
+// time there is one, and says what is in it.  It needs neither docker nor etcd.
+func CmdValidate(config *Config) error {
+	runnable := 0
+	for _, topo := range config.nameToTopology {
+		runnable += len(topo)
+	}
+	fmt.Printf("[pickett] configuration is valid: %d images and tests, %d topology entries in %d topologies, %d warnings\n",
+		len(config.nameToNode), runnable, len(config.nameToTopology), len(config.warnings))
+	return nil
+}
+
+// CmdGraph prints the dependency graph of the configuration in the DOT language of
+// graphviz.  Edges go from what is used to what uses it; images that pickett doesn't
+// build are dashed.  It needs neither docker nor etcd.
+func CmdGraph(config *Config) error {
+	return writeGraph(config, os.Stdout)
+}
+
+//writeGraph writes the dependency graph of config to w, in a stable order.
+func writeGraph(config *Config, w io.Writer) error {
+	lines := []string{}
+	external := make(map[string]bool)
+	names := []string{}
+	for name := range config.nameToNode {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("\t%q;", name))
+		for _, in := range config.nameToNode[name].implementation().in() {
+			lines = append(lines, fmt.Sprintf("\t%q -> %q;", in.name(), name))
+		}
+		//extractions may also use images that pickett doesn't build
+		if e, ok := config.nameToNode[name].implementation().(*extractionBuilder); ok {
+			for _, n := range []nodeOrName{e.runIn, e.mergeWith} {
+				if !n.isNode {
+					external[n.name] = true
+					lines = append(lines, fmt.Sprintf("\t%q -> %q;", n.name, name))
+				}
+			}
+		}
+	}
+
+	topos := []string{}
+	for t := range config.nameToTopology {
+		topos = append(topos, t)
+	}
+	sort.Strings(topos)
+	for _, t := range topos {
+		entries := []string{}
+		for e := range config.nameToTopology[t] {
+			entries = append(entries, e)
+		}
+		sort.Strings(entries)
+		for _, e := range entries {
+			name := t + "." + e
+			lines = append(lines, fmt.Sprintf("\t%q [shape=box];", name))
+			r, ok := config.nameToTopology[t][e].runner.(*topoRunner)
+			if !ok {
+				continue
+			}
+			if !r.runIn.isNode {
+				external[r.runIn.name] = true
+			}
+			lines = append(lines, fmt.Sprintf("\t%q -> %q;", r.runIn.name, name))
+			for _, other := range r.consumes {
+				lines = append(lines, fmt.Sprintf("\t%q -> %q;", other.topology()+"."+other.name(), name))
+			}
+		}
+	}
+	images := []string{}
+	for image := range external {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	for _, image := range images {
+		lines = append(lines, fmt.Sprintf("\t%q [style=dashed];", image))
+	}
+
+	if _, err := fmt.Fprintf(w, "digraph pickett {\n"); err != nil {
+		return err
+	}
+	for _, l := range lines {
+		if _, err := fmt.Fprintf(w, "%s\n", l); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "}\n")
+	return err
+}
@@ -0,0 +1,45 @@
+package pickett
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+func TestGraph(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	helper.EXPECT().OpenDockerfileRelative("somedir").Return(nil, nil)
+	setupForUserConfig(helper)
+
+	c, err := NewConfig(strings.NewReader(netExample), helper, nil, nil)
+	if err != nil {
+		t.Fatalf("can't read config without docker: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := writeGraph(c, &buf); err != nil {
+		t.Fatalf("can't write graph: %v", err)
+	}
+	expected := `digraph pickett {
+	"netexample:part1";
+	"netexample:uses-part1";
+	"netexample:part1" -> "netexample:uses-part1";
+	"someothergraph.part3" [shape=box];
+	"part3-image" -> "someothergraph.part3";
+	"someothergraph.part4" -> "someothergraph.part3";
+	"someothergraph.part4" [shape=box];
+	"part4-image" -> "someothergraph.part4";
+	"part3-image" [style=dashed];
+	"part4-image" [style=dashed];
+}
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected graph:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,89 @@
+package pickett
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+//newImageConfig checks the image settings of the node called owner and converts them
+//into what docker wants.  It returns nil if there are none.
+func newImageConfig(owner string, m *ImageMetadata) (*io.ImageConfig, error) {
+	if m == nil {
+		return nil, nil
+	}
+	result := &io.ImageConfig{
+		Entrypoint: m.Entrypoint,
+		Cmd:        m.Cmd,
+		WorkingDir: strings.Trim(m.WorkingDir, " \n"),
+		User:       strings.Trim(m.User, " \n"),
+		Labels:     m.Labels,
+	}
+	keys := []string{}
+	for k := range m.Env {
+		if k == "" || strings.ContainsAny(k, "= ") {
+			return nil, fmt.Errorf("bad environment variable name %q in the image of %s", k, owner)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		result.Env = append(result.Env, k+"="+m.Env[k])
+	}
+	for _, p := range m.ExposedPorts {
+		port, err := exposedPort(p)
+		if err != nil {
+			return nil, fmt.Errorf("bad exposed port %s in the image of %s: %v", p, owner, err)
+		}
+		result.ExposedPorts = append(result.ExposedPorts, port)
+	}
+	for k := range m.Labels {
+		if strings.HasPrefix(k, io.LABEL_PREFIX) {
+			return nil, fmt.Errorf("label %s in the image of %s is reserved for pickett", k, owner)
+		}
+	}
+	return result, nil
+}
+
+//exposedPort checks a port given as number or number/protocol and returns it in the
+//second form, which is what docker uses.
+func exposedPort(p string) (string, error) {
+	parts := strings.Split(strings.Trim(p, " \n"), "/")
+	if len(parts) > 2 {
+		return "", fmt.Errorf("should be port or port/protocol")
+	}
+	n, err := strconv.Atoi(parts[0])
+	if err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("not a port number")
+	}
+	proto := "tcp"
+	if len(parts) == 2 {
+		proto = strings.ToLower(parts[1])
+	}
+	if proto != "tcp" && proto != "udp" {
+		return "", fmt.Errorf("protocol should be tcp or udp")
+	}
+	return fmt.Sprintf("%d/%s", n, proto), nil
+}
+
+//withLabels returns a copy of imgConf with pickett's labels added to the ones of the
+//image.  imgConf may be nil.
+func withLabels(imgConf *io.ImageConfig, labels map[string]string) *io.ImageConfig {
+	result := &io.ImageConfig{}
+	if imgConf != nil {
+		*result = *imgConf
+	}
+	result.Labels = make(map[string]string)
+	if imgConf != nil {
+		for k, v := range imgConf.Labels {
+			result.Labels[k] = v
+		}
+	}
+	for k, v := range labels {
+		result.Labels[k] = v
+	}
+	return result
+}
@@ -0,0 +1,419 @@
+package pickett
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	pickett_io "github.com/igneous-systems/pickett/io"
+)
+
+//contributed are the sections whose entries an included file adds to the configuration.
+var contributed = []string{"Containers", "GoBuilds", "GoTests", "Extractions"}
+
+var configType = reflect.TypeOf(Config{})
+
+//source is a file that part of the configuration came from, and its directory relative to
+//the main configuration file.
+type source struct {
+	file string
+	dir  string
+}
+
+//sources says where the parts of a configuration that was put together from several files
+//came from.  A node that isn't in it came from the same file as the node it is in.
+type sources map[*yaml.Node]*source
+
+//file returns the file n came from, if it isn't the file of the node it is in, outer.
+func (from sources) file(n *yaml.Node, outer string) string {
+	if s, ok := from[n]; ok {
+		return s.file
+	}
+	return outer
+}
+
+//where names the place of n, which is in outer unless from says otherwise.
+func (from sources) where(n *yaml.Node, outer string) string {
+	return fmt.Sprintf("%s:%d", from.file(n, outer), n.Line)
+}
+
+//sectionOf returns the field of the configuration that key is for, or "".
+func sectionOf(key *yaml.Node) string {
+	if f, ok := fieldForKey(configType, key.Value); ok {
+		return f.Name
+	}
+	return ""
+}
+
+//section returns the value of the field name of the mapping n, adding an empty one of
+//kind if there isn't one.
+func section(n *yaml.Node, name string, kind yaml.Kind) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if sectionOf(n.Content[i]) == name {
+			if v := unalias(n.Content[i+1]); v.Kind == kind {
+				return v
+			}
+			//something that isn't a list or object, like null, is replaced
+			n.Content[i+1] = &yaml.Node{Kind: kind, Line: n.Content[i].Line, Column: n.Content[i].Column}
+			return n.Content[i+1]
+		}
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name, Line: n.Line, Column: n.Column}
+	value := &yaml.Node{Kind: kind, Line: n.Line, Column: n.Column}
+	n.Content = append(n.Content, key, value)
+	return value
+}
+
+//includer puts the files a configuration includes into it.  done are the files already
+//included and active are those whose includes are being followed.
+type includer struct {
+	helper   pickett_io.Helper
+	file     string
+	main     *yaml.Node
+	from     sources
+	done     map[string]bool
+	active   []string
+	warnings []string
+}
+
+//includeConfigs adds the entries of the files that the configuration doc, which came
+//from file, includes.  The nodes that came from them are put in from.  Keys of the
+//included files that have no place in the configuration are returned as warnings.
+func includeConfigs(helper pickett_io.Helper, file string, doc *yaml.Node, from sources) ([]string, error) {
+	if doc.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	in := &includer{
+		helper: helper,
+		file:   file,
+		main:   doc,
+		from:   from,
+		done:   map[string]bool{filepath.Base(file): true},
+		active: []string{filepath.Base(file)},
+	}
+	if err := in.include(&source{file: file, dir: "."}, doc); err != nil {
+		return nil, err
+	}
+	return in.warnings, nil
+}
+
+//include follows the Include of doc, which came from src.  Its paths are relative to
+//src.
+func (in *includer) include(src *source, doc *yaml.Node) error {
+	for _, pair := range mappingPairs(doc) {
+		if sectionOf(pair[0]) != "Include" {
+			continue
+		}
+		list := unalias(pair[1])
+		if list.Kind != yaml.SequenceNode {
+			return &ConfigError{File: src.file, Line: list.Line, Column: list.Column, Msg: "Include should be a list of files"}
+		}
+		for _, p := range list.Content {
+			p = unalias(p)
+			if p.Kind != yaml.ScalarNode || strings.TrimSpace(p.Value) == "" {
+				return &ConfigError{File: src.file, Line: p.Line, Column: p.Column, Msg: "Include should be a list of files"}
+			}
+			files, err := in.expand(path.Join(src.dir, strings.TrimSpace(p.Value)))
+			if err != nil {
+				return &ConfigError{File: src.file, Line: p.Line, Column: p.Column, Msg: err.Error()}
+			}
+			for _, f := range files {
+				if err := in.includeFile(src, p, f); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//expand returns the files the pattern p, relative to the main configuration, matches.
+//A pattern without wildcards is the file itself, whether it exists or not.
+func (in *includer) expand(p string) ([]string, error) {
+	if !strings.ContainsAny(p, "*?[") {
+		return []string{p}, nil
+	}
+	root := in.helper.DirectoryRelative(".")
+	matches, err := filepath.Glob(in.helper.DirectoryRelative(p))
+	if err != nil {
+		return nil, fmt.Errorf("bad pattern %s: %v", p, err)
+	}
+	if len(matches) == 0 {
+		flog.Warningf("Include %s matched no files", p)
+	}
+	result := []string{}
+	for _, m := range matches {
+		rel, err := filepath.Rel(root, m)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, filepath.ToSlash(rel))
+	}
+	return result, nil
+}
+
+//includeFile reads rel, which the node at of src includes, and adds its entries.
+func (in *includer) includeFile(src *source, at *yaml.Node, rel string) error {
+	if contains(in.active, rel) {
+		return &ConfigError{File: src.file, Line: at.Line, Column: at.Column, Msg: fmt.Sprintf("including %s again makes a loop", rel)}
+	}
+	if in.done[rel] {
+		return nil
+	}
+	in.done[rel] = true
+	rd, err := in.helper.OpenFileRelative(rel)
+	if err != nil {
+		return &ConfigError{File: src.file, Line: at.Line, Column: at.Column, Msg: fmt.Sprintf("can't include %s: %v", rel, err)}
+	}
+	content, err := ioutil.ReadAll(rd)
+	rd.Close()
+	if err != nil {
+		return fmt.Errorf("could not read all of %s: %v", rel, err)
+	}
+	doc, _, err := parseConfig(rel, content)
+	if err != nil {
+		return err
+	}
+	if doc.Kind != yaml.MappingNode {
+		return &ConfigError{File: rel, Line: doc.Line, Column: doc.Column, Msg: "an included file should be an object"}
+	}
+	inc := &source{file: rel, dir: path.Dir(rel)}
+	if err := in.splice(inc, doc); err != nil {
+		return err
+	}
+	in.active = append(in.active, rel)
+	defer func() { in.active = in.active[:len(in.active)-1] }()
+	return in.include(inc, doc)
+}
+
+//splice adds the entries of doc, which came from src, to the main configuration.
+func (in *includer) splice(src *source, doc *yaml.Node) error {
+	for _, pair := range mappingPairs(doc) {
+		k, v := pair[0], unalias(pair[1])
+		name := sectionOf(k)
+		switch {
+		case name == "":
+			msg := fmt.Sprintf("unknown key %s is ignored", k.Value)
+			in.warnings = append(in.warnings, (&ConfigError{File: src.file, Line: k.Line, Column: k.Column, Msg: msg}).Error())
+		case name == "Include":
+		case name == "Topologies":
+			if v.Kind != yaml.MappingNode {
+				return &ConfigError{File: src.file, Line: v.Line, Column: v.Column, Msg: "Topologies should be an object"}
+			}
+			topologies := section(in.main, "Topologies", yaml.MappingNode)
+			for _, topo := range mappingPairs(v) {
+				for i := 0; i+1 < len(topologies.Content); i += 2 {
+					if other := topologies.Content[i]; other.Value == topo[0].Value {
+						msg := fmt.Sprintf("topology %s is also defined at %s", topo[0].Value, in.from.where(other, in.file))
+						return &ConfigError{File: src.file, Line: topo[0].Line, Column: topo[0].Column, Msg: msg}
+					}
+				}
+				topologies.Content = append(topologies.Content, topo[0], topo[1])
+				in.from[topo[0]], in.from[topo[1]] = src, src
+			}
+		case contains(contributed, name):
+			if v.Kind != yaml.SequenceNode {
+				return &ConfigError{File: src.file, Line: v.Line, Column: v.Column, Msg: fmt.Sprintf("%s should be a list", k.Value)}
+			}
+			list := section(in.main, name, yaml.SequenceNode)
+			for _, entry := range v.Content {
+				list.Content = append(list.Content, entry)
+				in.from[entry] = src
+			}
+		default:
+			msg := fmt.Sprintf("%s can only be in the main configuration file", k.Value)
+			return &ConfigError{File: src.file, Line: k.Line, Column: k.Column, Msg: msg}
+		}
+	}
+	return nil
+}
+
+//applyOverlay changes the topology entries of doc, which came from file, as the overlay
+//read from reader says.  The nodes that came from the overlay are put in from.
+func applyOverlay(reader io.Reader, file string, doc *yaml.Node, from sources) error {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("could not read all of overlay: %v", err)
+	}
+	overlay, name, err := parseConfig(readerName(reader, ""), content)
+	if err != nil {
+		return err
+	}
+	src := &source{file: name, dir: "."}
+	if overlay.Kind != yaml.MappingNode || doc.Kind != yaml.MappingNode {
+		return &ConfigError{File: name, Line: overlay.Line, Column: overlay.Column, Msg: "an overlay should be an object"}
+	}
+	topologies := section(doc, "Topologies", yaml.MappingNode)
+	for _, pair := range mappingPairs(overlay) {
+		k, v := pair[0], unalias(pair[1])
+		if sectionOf(k) != "Topologies" {
+			msg := fmt.Sprintf("an overlay can only change Topologies, not %s", k.Value)
+			return &ConfigError{File: name, Line: k.Line, Column: k.Column, Msg: msg}
+		}
+		if v.Kind != yaml.MappingNode {
+			return &ConfigError{File: name, Line: v.Line, Column: v.Column, Msg: "Topologies should be an object"}
+		}
+		for _, topo := range mappingPairs(v) {
+			var entries *yaml.Node
+			for _, t := range mappingPairs(topologies) {
+				if t[0].Value == topo[0].Value {
+					entries = t[1]
+				}
+			}
+			patches := unalias(topo[1])
+			if entries == nil || entries.Kind != yaml.SequenceNode || patches.Kind != yaml.SequenceNode {
+				msg := fmt.Sprintf("no list of entries in a topology called %s to change", topo[0].Value)
+				return &ConfigError{File: name, Line: topo[0].Line, Column: topo[0].Column, Msg: msg}
+			}
+			for _, patch := range patches.Content {
+				if err := patchEntry(src, topo[0].Value, entries, unalias(patch), from); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//entryName returns the Name of the topology entry n.
+func entryName(n *yaml.Node) string {
+	if n.Kind != yaml.MappingNode {
+		return ""
+	}
+	for _, pair := range mappingPairs(n) {
+		if f, ok := fieldForKey(reflect.TypeOf(TopologyEntry{}), pair[0].Value); ok && f.Name == "Name" {
+			return strings.Trim(unalias(pair[1]).Value, " \n")
+		}
+	}
+	return ""
+}
+
+//patchEntry changes the entry of the topology that has the Name of patch, which came from
+//src.
+func patchEntry(src *source, topology string, entries *yaml.Node, patch *yaml.Node, from sources) error {
+	name := entryName(patch)
+	if name == "" {
+		return &ConfigError{File: src.file, Line: patch.Line, Column: patch.Column, Msg: "each entry of an overlay needs the Name of the entry it changes"}
+	}
+	for _, entry := range entries.Content {
+		if entry = unalias(entry); entryName(entry) == name {
+			mergePatch(entry, patch, reflect.TypeOf(TopologyEntry{}), src, from)
+			return nil
+		}
+	}
+	msg := fmt.Sprintf("no entry called %s in topology %s to change", name, topology)
+	return &ConfigError{File: src.file, Line: patch.Line, Column: patch.Column, Msg: msg}
+}
+
+//mergePatch changes the mapping base, which is decoded into t, like a JSON merge patch:
+//the keys of patch replace those of base, objects are merged and null removes a key.
+func mergePatch(base, patch *yaml.Node, t reflect.Type, src *source, from sources) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	same := func(a, b string) bool {
+		if t.Kind() != reflect.Struct {
+			return a == b
+		}
+		fa, okA := fieldForKey(t, a)
+		fb, okB := fieldForKey(t, b)
+		return okA && okB && fa.Name == fb.Name || a == b
+	}
+	for _, pair := range mappingPairs(patch) {
+		k, v := pair[0], unalias(pair[1])
+		i := 0
+		for ; i+1 < len(base.Content); i += 2 {
+			if same(base.Content[i].Value, k.Value) {
+				break
+			}
+		}
+		found := i+1 < len(base.Content)
+		var elem reflect.Type
+		switch t.Kind() {
+		case reflect.Struct:
+			if f, ok := fieldForKey(t, k.Value); ok {
+				elem = f.Type
+			}
+		case reflect.Map:
+			elem = t.Elem()
+		}
+		switch {
+		case v.ShortTag() == "!!null":
+			if found {
+				base.Content = append(base.Content[:i], base.Content[i+2:]...)
+			}
+		case found && elem != nil && v.Kind == yaml.MappingNode && unalias(base.Content[i+1]).Kind == yaml.MappingNode:
+			mergePatch(unalias(base.Content[i+1]), v, elem, src, from)
+		case found:
+			base.Content[i+1] = v
+			from[v] = src
+		default:
+			base.Content = append(base.Content, k, v)
+			from[k], from[v] = src, src
+		}
+	}
+}
+
+//rebase turns dir, a directory relative to the file src, into one relative to the main
+//configuration file.
+func rebase(src *source, dir string) string {
+	if src == nil || src.dir == "." || strings.TrimSpace(dir) == "" || filepath.IsAbs(strings.TrimSpace(dir)) {
+		return dir
+	}
+	return path.Join(src.dir, strings.TrimSpace(dir))
+}
+
+//settleIncluded makes the directories of entries that came from included files relative
+//to the main configuration file, and checks that no name is defined in two files.  doc
+//is what c was decoded from.
+func (c *Config) settleIncluded(file string, doc *yaml.Node, from sources) error {
+	if len(from) == 0 || doc.Kind != yaml.MappingNode {
+		return nil
+	}
+	lists := make(map[string]*yaml.Node)
+	for _, pair := range mappingPairs(doc) {
+		lists[sectionOf(pair[0])] = unalias(pair[1])
+	}
+	defined := make(map[string]*yaml.Node)
+	for _, section := range contributed {
+		list := lists[section]
+		if list == nil || list.Kind != yaml.SequenceNode {
+			continue
+		}
+		for i, entry := range list.Content {
+			src := from[entry]
+			var name string
+			switch section {
+			case "Containers":
+				ct := c.Containers[i]
+				name = strings.Trim(ct.Repository, " \n") + ":" + strings.Trim(ct.Tag, " \n")
+				ct.Directory = rebase(src, ct.Directory)
+			case "GoBuilds":
+				b := c.GoBuilds[i]
+				name = strings.Trim(b.Repository, " \n") + ":" + strings.Trim(b.Tag, " \n")
+				b.ModuleDir = rebase(src, b.ModuleDir)
+				b.TestFile = rebase(src, b.TestFile)
+			case "GoTests":
+				t := c.GoTests[i]
+				name = strings.Trim(t.Name, " \n")
+				t.ModuleDir = rebase(src, t.ModuleDir)
+				t.ReportDir = rebase(src, t.ReportDir)
+			case "Extractions":
+				e := c.Extractions[i]
+				name = strings.Trim(e.Repository, " \n") + ":" + strings.Trim(e.Tag, " \n")
+			}
+			other, ok := defined[name]
+			if ok && from.file(other, file) != from.file(entry, file) {
+				return fmt.Errorf("%s is defined at both %s and %s", name, from.where(other, file), from.where(entry, file))
+			}
+			defined[name] = entry
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package pickett
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+var includeMain = `{
+	"Include" : [ "parts/*.yaml" ],
+	"Containers" : [
+		{ "Repository": "blah", "Tag" : "bletch", "Directory" : "mydir" }
+	],
+	"Topologies" : {
+		"main" : [
+			{ "Name" : "web", "RunIn" : "blah:bletch", "EntryPoint" : [ "serve" ], "Instances" : 1 }
+		]
+	}
+}`
+
+var includeDB = `Include: [ other.toml ]
+Containers:
+  - Repository: db
+    Tag: latest
+    Directory: dbdir
+Topologies:
+  infra:
+    - Name: db
+      RunIn: db:latest
+`
+
+var includeOther = `[[Containers]]
+Repository = "cache"
+Tag = "latest"
+Directory = "../cachedir"
+`
+
+var includeOverlay = `Topologies:
+  main:
+    - Name: web
+      Instances: 2
+      EntryPoint: null
+`
+
+//includeFiles writes files, by their names, to a new directory and has helper find
+//them there.  The directory should be removed by the caller.
+func includeFiles(t *testing.T, helper *io.MockHelper, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "pickett")
+	if err != nil {
+		t.Fatalf("can't make a directory: %v", err)
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("can't write %s: %v", name, err)
+		}
+		rd, err := os.Open(p)
+		if err != nil {
+			t.Fatalf("can't open %s: %v", name, err)
+		}
+		helper.EXPECT().OpenFileRelative(name).Return(rd, nil).AnyTimes()
+	}
+	helper.EXPECT().DirectoryRelative(".").Return(dir).AnyTimes()
+	helper.EXPECT().DirectoryRelative("parts/*.yaml").Return(filepath.Join(dir, "parts", "*.yaml")).AnyTimes()
+	return dir
+}
+
+func TestIncludeAndOverlay(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	dir := includeFiles(t, helper, map[string]string{
+		"parts/db.yaml":    includeDB,
+		"parts/other.toml": includeOther,
+	})
+	defer os.RemoveAll(dir)
+
+	helper.EXPECT().OpenDockerfileRelative("mydir").Return(nil, nil)
+	helper.EXPECT().OpenDockerfileRelative("parts/dbdir").Return(nil, nil)
+	helper.EXPECT().OpenDockerfileRelative("cachedir").Return(nil, nil)
+
+	options := &ConfigOptions{}
+	options.Overlays = append(options.Overlays, strings.NewReader(includeOverlay))
+	c, err := NewConfigWithOptions(strings.NewReader(includeMain), helper, cli, nil, options)
+	if err != nil {
+		t.Fatalf("can't read included files: %v", err)
+	}
+	if len(c.Containers) != 3 {
+		t.Fatalf("expected containers from every file: %+v", c.Containers)
+	}
+	if c.Containers[1].Directory != "parts/dbdir" || c.Containers[2].Directory != "cachedir" {
+		t.Errorf("expected directories relative to the main file: %s, %s", c.Containers[1].Directory, c.Containers[2].Directory)
+	}
+	if len(c.Topologies["infra"]) != 1 {
+		t.Errorf("expected the included topology: %v", c.Topologies)
+	}
+	web := c.Topologies["main"][0]
+	if web.Instances != 2 || web.EntryPoint != nil || web.RunIn != "blah:bletch" {
+		t.Errorf("expected the overlay to change web: %+v", web)
+	}
+}
+
+func TestIncludeMistakes(t *testing.T) {
+	mistakes := []struct {
+		files    map[string]string
+		expected string
+	}{
+		{
+			map[string]string{"parts/a.yaml": "Include: [ b.yaml ]\n", "parts/b.yaml": "Include: [ a.yaml ]\n"},
+			"parts/b.yaml:1:12: including parts/a.yaml again makes a loop",
+		},
+		{
+			map[string]string{"parts/a.yaml": "Containers:\n  - Repository: blah\n    Tag: bletch\n    Directory: x\n"},
+			"blah:bletch is defined at both Pickett.json:4 and parts/a.yaml:2",
+		},
+		{
+			map[string]string{"parts/a.yaml": "Topologies:\n  main: []\n"},
+			"parts/a.yaml:2:3: topology main is also defined at Pickett.json:7",
+		},
+		{
+			map[string]string{"parts/a.yaml": "CodeVolumes: []\n"},
+			"parts/a.yaml:1:1: CodeVolumes can only be in the main configuration file",
+		},
+	}
+	for _, m := range mistakes {
+		controller := gomock.NewController(t)
+		helper := io.NewMockHelper(controller)
+		cli := io.NewMockDockerCli(controller)
+		dir := includeFiles(t, helper, m.files)
+		_, err := NewConfigWithOptions(strings.NewReader(includeMain), helper, cli, nil, nil)
+		if err == nil || err.Error() != m.expected {
+			t.Errorf("expected %q, got %v", m.expected, err)
+		}
+		os.RemoveAll(dir)
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	dir := includeFiles(t, helper, map[string]string{})
+	defer os.RemoveAll(dir)
+	overlay := "Topologies:\n  main:\n    - Name: nope\n      Instances: 2\n"
+	options := &ConfigOptions{}
+	options.Overlays = append(options.Overlays, strings.NewReader(overlay))
+	_, err := NewConfigWithOptions(strings.NewReader(includeMain), helper, cli, nil, options)
+	if err == nil || err.Error() != "Pickett.yaml:3:7: no entry called nope in topology main to change" {
+		t.Errorf("expected an unknown entry, got %v", err)
+	}
+}
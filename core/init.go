@@ -0,0 +1,407 @@
+package pickett
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	pickett_io "github.com/igneous-systems/pickett/io"
+)
+
+//These are the images pickett init looks for to build go programs in, and suggests to
+//run them in.
+const (
+	INIT_GO_IMAGE  = "golang"
+	INIT_RUN_IMAGE = "debian:stable-slim"
+)
+
+//INIT_MOUNT is where pickett init mounts the project for go builds.
+const INIT_MOUNT = "/src"
+
+//skippedDirs are directories pickett init doesn't look in, besides hidden ones.
+var skippedDirs = []string{"vendor", "node_modules", "testdata", "Godeps"}
+
+//unsafeName is what can't be in the name of a docker repository.
+var unsafeName = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+//initProject is what pickett init found in a directory tree, and the names it chose.
+type initProject struct {
+	name       string
+	containers []*initContainer
+	mains      []*initMain
+	skipped    []string
+	taken      map[string]bool
+}
+
+//initContainer is a directory with a Dockerfile.
+type initContainer struct {
+	Container
+	from []string
+}
+
+//initMain is a go main package in a module.
+type initMain struct {
+	moduleDir string
+	pkg       string
+	command   string
+	build     *GoBuild
+	extract   *Extraction
+}
+
+//asker gets answers to the questions of pickett init, or takes the defaults if yes.
+type asker struct {
+	yes bool
+	in  *bufio.Reader
+}
+
+//ask returns the answer to question, or def if there is none.
+func (a *asker) ask(question string, def string) string {
+	if a.yes {
+		return def
+	}
+	fmt.Printf("[pickett] %s [%s] ", question, def)
+	line, _ := a.in.ReadString('\n')
+	if line = strings.TrimSpace(line); line != "" {
+		return line
+	}
+	return def
+}
+
+//confirm asks a yes or no question, where yes is the default.
+func (a *asker) confirm(question string) bool {
+	answer := strings.ToLower(a.ask(question, "Y/n"))
+	return answer == "y/n" || strings.HasPrefix(answer, "y")
+}
+
+// CmdInit writes a configuration file, file, for the project in dir.  Each directory
+// with a Dockerfile becomes a container, which depends on the others it is built FROM,
+// and each go main package in a module becomes a go build and an extraction that puts
+// the program in an image of its own.  Unless yes, each of these is offered with a
+// question answered from in.  The configuration is checked after it is written.
+func CmdInit(dir string, file string, yes bool, in io.Reader) error {
+	name := filepath.Join(dir, file)
+	if _, err := os.Stat(name); err == nil {
+		return fmt.Errorf("%s already exists", name)
+	}
+	project, err := scanProject(dir)
+	if err != nil {
+		return err
+	}
+	for _, s := range project.skipped {
+		fmt.Printf("[pickett] skipping main package in %s, it is not in a go module\n", s)
+	}
+	a := &asker{yes: yes, in: bufio.NewReader(in)}
+	project.choose(a)
+
+	out := project.write()
+	if format := FormatOfFile(file); format != "" && format != FORMAT_JSON {
+		if out, err = ConvertConfig(bytes.NewReader(out), format); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(name, out, 0644); err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	helper, err := pickett_io.NewHelper(abs)
+	if err != nil {
+		return err
+	}
+	if _, err := NewConfig(helper.ConfigReader(), helper, nil, nil); err != nil {
+		os.Remove(name)
+		return fmt.Errorf("the configuration made for %s doesn't work, so it wasn't kept: %v", dir, err)
+	}
+	fmt.Printf("[pickett] wrote %s: %d containers, %d go builds\n", name, len(project.containers), len(project.mains))
+	return nil
+}
+
+//scanProject finds the Dockerfiles and go main packages below dir.
+func scanProject(dir string) (*initProject, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	project := &initProject{
+		name:  repositoryName(filepath.Base(abs)),
+		taken: make(map[string]bool),
+	}
+	if project.name == "" {
+		project.name = "project"
+	}
+	mains := make(map[string]bool)
+	err = filepath.Walk(abs, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(abs, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			base := info.Name()
+			if rel != "." && (strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_") || contains(skippedDirs, base)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch {
+		case info.Name() == "Dockerfile":
+			content, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			project.addContainer(path.Dir(rel), dockerfileRefs(content, nil))
+		case strings.HasSuffix(info.Name(), ".go") && !strings.HasSuffix(info.Name(), "_test.go"):
+			if mains[path.Dir(rel)] {
+				return nil
+			}
+			f, err := parser.ParseFile(token.NewFileSet(), p, nil, parser.PackageClauseOnly)
+			if err == nil && f.Name.Name == "main" {
+				mains[path.Dir(rel)] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	dirs := []string{}
+	for d := range mains {
+		dirs = append(dirs, d)
+	}
+	sort.Strings(dirs)
+	for _, d := range dirs {
+		if module, ok := moduleOf(abs, d); ok {
+			project.addMain(module, d)
+		} else {
+			project.skipped = append(project.skipped, d)
+		}
+	}
+	return project, nil
+}
+
+//repositoryName turns s into something that can be part of the name of a docker
+//repository.
+func repositoryName(s string) string {
+	return strings.Trim(unsafeName.ReplaceAllString(strings.ToLower(s), "-"), "-._")
+}
+
+//newName returns a repository in the project named for dir that no other entry has.
+func (p *initProject) newName(dir string, suffix string) string {
+	name := p.name
+	if dir != "." {
+		name = p.name + "/" + repositoryName(path.Base(dir)) + suffix
+	} else if suffix != "" {
+		name = p.name + "/" + p.name + suffix
+	}
+	if p.taken[name] && dir != "." {
+		name = p.name + "/" + repositoryName(strings.Replace(dir, "/", "-", -1)) + suffix
+	}
+	for i := 2; p.taken[name]; i++ {
+		name = fmt.Sprintf("%s-%d", strings.TrimSuffix(name, fmt.Sprintf("-%d", i-1)), i)
+	}
+	p.taken[name] = true
+	return name
+}
+
+//addContainer adds the Dockerfile in dir, which refers to the images from.
+func (p *initProject) addContainer(dir string, from []string) {
+	c := &initContainer{from: from}
+	c.Repository = p.newName(dir, "")
+	c.Tag = "latest"
+	c.Directory = dir
+	p.containers = append(p.containers, c)
+}
+
+//moduleOf returns the directory of the go module that dir is in, looking no higher than
+//root.
+func moduleOf(root string, dir string) (string, bool) {
+	for d := dir; ; d = path.Dir(d) {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(d), "go.mod")); err == nil {
+			return d, true
+		}
+		if d == "." {
+			return "", false
+		}
+	}
+}
+
+//addMain adds the main package in dir, of the module in moduleDir.
+func (p *initProject) addMain(moduleDir string, dir string) {
+	m := &initMain{moduleDir: moduleDir, pkg: "."}
+	if dir != moduleDir {
+		rel := strings.TrimPrefix(dir, moduleDir+"/")
+		if moduleDir == "." {
+			rel = dir
+		}
+		m.pkg = "./" + rel
+	}
+	m.command = repositoryName(path.Base(dir))
+	if dir == "." {
+		m.command = p.name
+	}
+	m.build = &GoBuild{
+		Repository: p.newName(dir, "-build"),
+		Tag:        "latest",
+		ModuleDir:  moduleDir,
+		Packages:   []string{m.pkg},
+		Outputs:    map[string]string{m.pkg: "/usr/local/bin/" + m.command},
+	}
+	m.extract = &Extraction{
+		Repository: p.newName(dir, ""),
+		Tag:        "latest",
+		Artifacts:  []*Artifact{{BuiltPath: m.build.Outputs[m.pkg], DestinationDir: "/usr/local/bin"}},
+	}
+	p.mains = append(p.mains, m)
+}
+
+//choose works out what the entries depend on and keeps the ones a wants.
+func (p *initProject) choose(a *asker) {
+	kept := []*initContainer{}
+	for _, c := range p.containers {
+		if a.confirm(fmt.Sprintf("Add container %s from %s/Dockerfile?", c.Repository, c.Directory)) {
+			kept = append(kept, c)
+		}
+	}
+	p.containers = kept
+	names := make(map[string]*initContainer)
+	for _, c := range p.containers {
+		names[c.Repository+":"+c.Tag] = c
+	}
+	goImage := ""
+	for _, c := range p.containers {
+		for _, ref := range c.from {
+			if other, ok := names[imageNodeName(ref)]; ok && other != c {
+				c.DependsOn = append(c.DependsOn, imageNodeName(ref))
+			}
+			if strings.HasPrefix(ref, INIT_GO_IMAGE) && goImage == "" {
+				//a container built from go can build go
+				goImage = c.Repository + ":" + c.Tag
+			}
+		}
+	}
+	if len(p.mains) == 0 {
+		return
+	}
+
+	//go builds run in one of the containers
+	goImage = a.ask("Container to build go programs in?", goImage)
+	if _, ok := names[goImage]; !ok {
+		fmt.Printf("[pickett] go programs are built in one of the containers, like one FROM %s; skipping them\n", INIT_GO_IMAGE)
+		p.mains = nil
+		return
+	}
+	keptMains := []*initMain{}
+	for _, m := range p.mains {
+		if !a.confirm(fmt.Sprintf("Build %s in %s and put it in image %s?", m.command, path.Join(m.moduleDir, m.pkg), m.extract.Repository)) {
+			continue
+		}
+		m.build.RunIn = goImage
+		m.extract.RunIn = m.build.Repository + ":" + m.build.Tag
+		m.extract.MergeWith = a.ask(fmt.Sprintf("Image to run %s in?", m.command), INIT_RUN_IMAGE)
+		keptMains = append(keptMains, m)
+	}
+	p.mains = keptMains
+}
+
+//initWriter writes a configuration with comments, which encoding/json can't.
+type initWriter struct {
+	buf bytes.Buffer
+}
+
+//comment writes a comment line at depth.
+func (w *initWriter) comment(depth int, format string, args ...interface{}) {
+	fmt.Fprintf(&w.buf, "%s// %s\n", strings.Repeat("\t", depth), fmt.Sprintf(format, args...))
+}
+
+//object writes an object at depth, with keys in the order given and values written
+//compactly.  last says whether it is the last in its list.
+func (w *initWriter) object(depth int, pairs []interface{}, last bool) {
+	tabs := strings.Repeat("\t", depth)
+	w.buf.WriteString(tabs + "{\n")
+	for i := 0; i < len(pairs); i += 2 {
+		value, _ := json.Marshal(pairs[i+1])
+		comma := ","
+		if i+2 >= len(pairs) {
+			comma = ""
+		}
+		fmt.Fprintf(&w.buf, "%s\t%q : %s%s\n", tabs, pairs[i], value, comma)
+	}
+	w.buf.WriteString(tabs + "}")
+	if !last {
+		w.buf.WriteString(",")
+	}
+	w.buf.WriteString("\n")
+}
+
+//write returns the configuration for what was chosen of the project.
+func (p *initProject) write() []byte {
+	w := &initWriter{}
+	w.comment(0, "Configuration for %s, written by pickett init.  Check the names and images, and add", p.name)
+	w.comment(0, "Topologies to run what is built.  Comments like these are allowed.")
+	w.buf.WriteString("{\n")
+	sections := []string{}
+	if len(p.mains) > 0 {
+		sections = append(sections, "CodeVolumes", "GoBuilds", "Extractions")
+	}
+	if len(p.containers) > 0 {
+		sections = append([]string{"Containers"}, sections...)
+	}
+	for s, section := range sections {
+		fmt.Fprintf(&w.buf, "\t%q : [\n", section)
+		switch section {
+		case "Containers":
+			for i, c := range p.containers {
+				w.comment(2, "built from %s/Dockerfile", c.Directory)
+				pairs := []interface{}{"Repository", c.Repository, "Tag", c.Tag, "Directory", c.Directory}
+				if len(c.DependsOn) > 0 {
+					pairs = append(pairs, "DependsOn", c.DependsOn)
+				}
+				w.object(2, pairs, i == len(p.containers)-1)
+			}
+		case "CodeVolumes":
+			w.comment(2, "the project, where the go builds find their source")
+			w.object(2, []interface{}{"Directory", ".", "MountedAt", INIT_MOUNT}, true)
+		case "GoBuilds":
+			for i, m := range p.mains {
+				w.comment(2, "builds %s, of the module in %s", m.command, m.moduleDir)
+				b := m.build
+				w.object(2, []interface{}{"Repository", b.Repository, "Tag", b.Tag, "RunIn", b.RunIn,
+					"ModuleDir", b.ModuleDir, "Packages", b.Packages, "Outputs", b.Outputs}, i == len(p.mains)-1)
+			}
+		case "Extractions":
+			for i, m := range p.mains {
+				w.comment(2, "puts %s in an image of its own", m.command)
+				e := m.extract
+				artifacts := []map[string]string{}
+				for _, a := range e.Artifacts {
+					artifacts = append(artifacts, map[string]string{"BuiltPath": a.BuiltPath, "DestinationDir": a.DestinationDir})
+				}
+				w.object(2, []interface{}{"Repository", e.Repository, "Tag", e.Tag, "RunIn", e.RunIn,
+					"MergeWith", e.MergeWith, "Artifacts", artifacts}, i == len(p.mains)-1)
+			}
+		}
+		if s == len(sections)-1 {
+			w.buf.WriteString("\t]\n")
+		} else {
+			w.buf.WriteString("\t],\n")
+		}
+	}
+	w.buf.WriteString("}\n")
+	return w.buf.Bytes()
+}
@@ -0,0 +1,114 @@
+package pickett
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//initTree is a project with a builder and a runner image and two go programs, one of
+//which is not in a module.
+var initTree = map[string]string{
+	"container/builder/Dockerfile": "FROM golang:1.12\n",
+	"container/runner/Dockerfile":  "ARG BASE=sample1/builder\nFROM ${BASE} AS build\nFROM ubuntu:14.04\nCOPY --from=build /x /x\n",
+	"src/go.mod":                   "module example.com/sample1\n",
+	"src/cmd/server/main.go":       "package main\n\nfunc main() {}\n",
+	"src/lib/lib.go":               "package lib\n",
+	"old/tool/main.go":             "package main\n\nfunc main() {}\n",
+	"vendor/x/main.go":             "package main\n",
+}
+
+func TestInit(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "pickett")
+	if err != nil {
+		t.Fatalf("can't make a directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "sample1")
+	for name, content := range initTree {
+		p := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("can't write %s: %v", name, err)
+		}
+	}
+
+	if err := CmdInit(dir, "Pickett.json", true, nil); err != nil {
+		t.Fatalf("can't init: %v", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, "Pickett.json"))
+	if err != nil {
+		t.Fatalf("nothing written: %v", err)
+	}
+	var c Config
+	warnings, err := decodeConfig("Pickett.json", content, &c)
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("can't read what was written: %v %v\n%s", err, warnings, content)
+	}
+	if !strings.HasPrefix(string(content), "// ") {
+		t.Errorf("expected comments:\n%s", content)
+	}
+	if len(c.Containers) != 2 || c.Containers[0].Repository != "sample1/builder" {
+		t.Fatalf("expected two containers: %s", content)
+	}
+	runner := c.Containers[1]
+	if runner.Directory != "container/runner" || len(runner.DependsOn) != 1 || runner.DependsOn[0] != "sample1/builder:latest" {
+		t.Errorf("expected the runner to depend on the builder: %+v", runner)
+	}
+	if len(c.GoBuilds) != 1 || len(c.Extractions) != 1 {
+		t.Fatalf("expected one go program: %s", content)
+	}
+	b := c.GoBuilds[0]
+	if b.ModuleDir != "src" || b.Packages[0] != "./cmd/server" || b.RunIn != "sample1/builder:latest" || b.Outputs["./cmd/server"] != "/usr/local/bin/server" {
+		t.Errorf("unexpected go build: %+v", b)
+	}
+	e := c.Extractions[0]
+	if e.Repository != "sample1/server" || e.RunIn != "sample1/server-build:latest" || e.MergeWith != INIT_RUN_IMAGE {
+		t.Errorf("unexpected extraction: %+v", e)
+	}
+	if len(c.CodeVolumes) != 1 || c.CodeVolumes[0].Directory != "." {
+		t.Errorf("expected the project as a code volume: %+v", c.CodeVolumes)
+	}
+
+	if err := CmdInit(dir, "Pickett.json", true, nil); err == nil {
+		t.Errorf("expected an existing configuration to be kept")
+	}
+
+	//no to the runner, yes to the rest, and a different image to run in
+	answers := "\nn\n\n\nbusybox\n"
+	if err := CmdInit(dir, "Pickett.yaml", false, strings.NewReader(answers)); err != nil {
+		t.Fatalf("can't init interactively: %v", err)
+	}
+	content, err = ioutil.ReadFile(filepath.Join(dir, "Pickett.yaml"))
+	if err != nil {
+		t.Fatalf("nothing written: %v", err)
+	}
+	c = Config{}
+	if _, err := decodeConfig("Pickett.yaml", content, &c); err != nil {
+		t.Fatalf("can't read what was written: %v", err)
+	}
+	if len(c.Containers) != 1 || c.GoBuilds[0].RunIn != "sample1/builder:latest" || c.Extractions[0].MergeWith != "busybox" {
+		t.Errorf("expected the answers to be used: %s", content)
+	}
+	if !strings.HasPrefix(string(content), "# Configuration for sample1") || !strings.Contains(string(content), "# builds server, of the module in src") {
+		t.Errorf("expected comments:\n%s", content)
+	}
+
+	//without a container to build in, there are no go builds
+	if err := CmdInit(dir, "Pickett.toml", false, strings.NewReader("n\n\n\n")); err != nil {
+		t.Fatalf("can't init interactively: %v", err)
+	}
+	content, err = ioutil.ReadFile(filepath.Join(dir, "Pickett.toml"))
+	if err != nil {
+		t.Fatalf("nothing written: %v", err)
+	}
+	c = Config{}
+	if _, err := decodeConfig("Pickett.toml", content, &c); err != nil || len(c.Containers) != 1 || len(c.GoBuilds) != 0 {
+		t.Errorf("expected only the runner: %v %s", err, content)
+	}
+	if !strings.HasPrefix(string(content), "# Configuration for sample1") || !strings.Contains(string(content), "# built from ") {
+		t.Errorf("expected comments:\n%s", content)
+	}
+}
@@ -0,0 +1,239 @@
+package pickett
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// ConfigError is a problem at a particular place in a configuration file.
+type ConfigError struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Column == 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+//readerName returns the name of the file r reads, or fallback if it isn't a file.
+func readerName(r io.Reader, fallback string) string {
+	if named, ok := r.(interface {
+		Name() string
+	}); ok {
+		return named.Name()
+	}
+	return fallback
+}
+
+//position returns an error about the place offset bytes into content.
+func position(file string, content []byte, offset int64, msg string) *ConfigError {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	before := content[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndex(before, []byte("\n"))
+	return &ConfigError{File: file, Line: line, Column: column, Msg: msg}
+}
+
+//cleanJSONC turns JSON with // and /* */ comments and trailing commas into plain JSON.
+//The comments and commas are replaced by spaces, so a place in the result is at the
+//same line and column in content.
+func cleanJSONC(file string, content []byte) ([]byte, error) {
+	out := append([]byte{}, content...)
+	blank := func(from, to int) {
+		for i := from; i < to; i++ {
+			if out[i] != '\n' {
+				out[i] = ' '
+			}
+		}
+	}
+	lastComma := -1
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+		switch {
+		case c == '"':
+			start := i
+			for i++; i < len(out) && out[i] != '"'; i++ {
+				if out[i] == '\\' {
+					i++
+				} else if out[i] == '\n' {
+					break
+				}
+			}
+			if i >= len(out) || out[i] != '"' {
+				return nil, position(file, content, int64(start), "string is not terminated")
+			}
+			lastComma = -1
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			end := bytes.IndexByte(out[i:], '\n')
+			if end == -1 {
+				end = len(out) - i
+			}
+			blank(i, i+end)
+			i += end - 1
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			end := bytes.Index(out[i+2:], []byte("*/"))
+			if end == -1 {
+				return nil, position(file, content, int64(i), "comment is not terminated")
+			}
+			blank(i, i+2+end+2)
+			i += 2 + end + 1
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		case c == '}' || c == ']':
+			if lastComma != -1 {
+				out[lastComma] = ' '
+			}
+			lastComma = -1
+		case c == ',':
+			lastComma = i
+		default:
+			lastComma = -1
+		}
+	}
+	return out, nil
+}
+
+//decodeJSONC decodes the JSON with comments in content, which came from file, into v.
+//It returns a warning for each key that has no place in v.
+func decodeJSONC(file string, content []byte, v interface{}) ([]string, error) {
+	clean, err := cleanJSONC(file, content)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(file, clean, func(offset int64, msg string) *ConfigError {
+		return position(file, content, offset, msg)
+	}, v)
+}
+
+//positioner returns an error about the place in the original file that was offset
+//bytes into the JSON being decoded.
+type positioner func(offset int64, msg string) *ConfigError
+
+//decodeJSON decodes data, which was made from file, into v.  It returns a warning for
+//each key that has no place in v.
+func decodeJSON(file string, data []byte, at positioner, v interface{}) ([]string, error) {
+	if err := json.Unmarshal(data, v); err != nil {
+		switch e := err.(type) {
+		case *json.SyntaxError:
+			return nil, at(e.Offset-1, e.Error())
+		case *json.UnmarshalTypeError:
+			msg := fmt.Sprintf("%s should be %s, not %s", e.Field, e.Type, e.Value)
+			return nil, at(e.Offset-1, msg)
+		}
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	w := &keyWalker{at: at, dec: json.NewDecoder(bytes.NewReader(data))}
+	if err := w.walk(reflect.TypeOf(v), ""); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return w.warnings, nil
+}
+
+//keyWalker goes through a JSON document alongside the type it is decoded into,
+//looking for keys that the type has no field for.
+type keyWalker struct {
+	at       positioner
+	dec      *json.Decoder
+	warnings []string
+}
+
+//walk reads the next value from the document.  t is the type it is decoded into, or nil
+//if anything goes.
+func (w *keyWalker) walk(t reflect.Type, path string) error {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	tok, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('{'):
+		for w.dec.More() {
+			keyTok, err := w.dec.Token()
+			if err != nil {
+				return err
+			}
+			key := keyTok.(string)
+			var elem reflect.Type
+			switch {
+			case t == nil || t.Kind() == reflect.Interface:
+			case t.Kind() == reflect.Map:
+				elem = t.Elem()
+			case t.Kind() == reflect.Struct:
+				f, ok := fieldForKey(t, key)
+				if !ok {
+					offset := w.dec.InputOffset() - int64(len(key)) - 2
+					msg := fmt.Sprintf("unknown key %s is ignored", joinPath(path, key))
+					w.warnings = append(w.warnings, w.at(offset, msg).Error())
+				} else {
+					elem = f.Type
+				}
+			}
+			if err := w.walk(elem, joinPath(path, key)); err != nil {
+				return err
+			}
+		}
+		_, err = w.dec.Token()
+		return err
+	case json.Delim('['):
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		for i := 0; w.dec.More(); i++ {
+			if err := w.walk(elem, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		_, err = w.dec.Token()
+		return err
+	}
+	return nil
+}
+
+//joinPath names the key in the object at path.
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+//fieldForKey finds the field of the struct type t that encoding/json would put the
+//value of key in.
+func fieldForKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	var folded *reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		if name == key {
+			return f, true
+		}
+		if folded == nil && strings.EqualFold(name, key) {
+			folded = &f
+		}
+	}
+	if folded != nil {
+		return *folded, true
+	}
+	return reflect.StructField{}, false
+}
@@ -33,6 +33,9 @@ type runner interface {
 	//this returns a map of the results, as containers
 	run(bool, *Config, string, int, *runVolumeSpec) (*policyInput, error)
 
+	//the topology the runner belongs to, which is where its containers are kept
+	topology() string
+
 	//some misc params for the run
 	imageName() string
 	exposed() map[io.Port][]io.PortBinding
@@ -41,6 +44,7 @@ type runner interface {
 	privileged() bool
 	waitFor() bool
 	contName() string
+	inputDigest() string
 
 	//note that this method is not really asking a question of the runner, it's asking a
 	//question about the *image* that the runner executes in
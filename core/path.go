@@ -3,10 +3,43 @@ package pickett
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
+//These are the legal values of PathTranslation.
+const (
+	TRANSLATE_AUTO = "AUTO"
+	TRANSLATE_NONE = "NONE"
+)
+
+// PathMapping says that the directory HostPath on this machine is visible to the docker
+// daemon as DaemonPath.
+type PathMapping struct {
+	HostPath   string
+	DaemonPath string
+}
+
+//USER_CONFIG_FILE is the name used in messages about the user's configuration file.
+const USER_CONFIG_FILE = "~/.pickett.json"
+
+// UserConfig is the per-user configuration, kept in ~/.pickett.json.  It holds the settings
+// that depend on the machine pickett is run on rather than on the project.  Anything set
+// here overrides the same setting in the project's configuration file.
+type UserConfig struct {
+	PathTranslation string
+	PathMappings    []*PathMapping
+}
+
+//pathTranslator knows how to turn a path on this machine into the path that the docker
+//daemon needs to see the same thing.
+type pathTranslator struct {
+	mode     string
+	mappings []*PathMapping
+}
+
 // needsPathTranslation determines whether we should map from $HOME to /vagrant.
 // Right now, we do so if the transport specified by DOCKER_HOST is TCP.
 func needsPathTranslation() bool {
@@ -30,3 +63,141 @@ func translatePath(path string) (string, error) {
 	flog.Debugf("no virtualbox mappings, guessing %s -> %s...", path, result)
 	return result, nil
 }
+
+//newPathTranslator checks the mode and mappings and returns a translator that uses them.
+//Host paths may start with ~ to mean $HOME.
+func newPathTranslator(mode string, mappings []*PathMapping) (*pathTranslator, error) {
+	result := &pathTranslator{
+		mode: strings.ToUpper(strings.Trim(mode, " \n")),
+	}
+	switch result.mode {
+	case "":
+		result.mode = TRANSLATE_AUTO
+	case TRANSLATE_AUTO, TRANSLATE_NONE:
+	default:
+		return nil, fmt.Errorf("unknown PathTranslation %s, should be %s or %s", mode, TRANSLATE_AUTO, TRANSLATE_NONE)
+	}
+	for _, m := range mappings {
+		host := strings.Trim(m.HostPath, " \n")
+		if strings.HasPrefix(host, "~") {
+			host = os.Getenv("HOME") + host[1:]
+		}
+		daemon := strings.Trim(m.DaemonPath, " \n")
+		if !filepath.IsAbs(host) || !filepath.IsAbs(daemon) {
+			return nil, fmt.Errorf("path mapping %s -> %s must use absolute paths", m.HostPath, m.DaemonPath)
+		}
+		result.mappings = append(result.mappings, &PathMapping{
+			HostPath:   filepath.Clean(host),
+			DaemonPath: filepath.Clean(daemon),
+		})
+	}
+	return result, nil
+}
+
+//translate returns the path the daemon should use for path, which is an absolute path
+//on this machine.  The first mapping that contains path is used.  If there are no
+//mappings, we fall back to guessing a vagrant mapping when DOCKER_HOST is tcp.
+func (t *pathTranslator) translate(path string) (string, error) {
+	if t.mode == TRANSLATE_NONE {
+		return path, nil
+	}
+	if len(t.mappings) == 0 {
+		if !needsPathTranslation() {
+			return path, nil
+		}
+		return translatePath(path)
+	}
+	if result, ok := t.mapped(path); ok {
+		return result, nil
+	}
+	return "", fmt.Errorf("no path mapping covers %s", path)
+}
+
+//mapped returns path as the daemon sees it, by the first mapping that contains it, and
+//whether there is one.
+func (t *pathTranslator) mapped(path string) (string, bool) {
+	clean := filepath.Clean(path)
+	for _, m := range t.mappings {
+		if clean == m.HostPath {
+			return m.DaemonPath, true
+		}
+		if strings.HasPrefix(clean, strings.TrimRight(m.HostPath, "/")+"/") {
+			result := filepath.Join(m.DaemonPath, clean[len(m.HostPath):])
+			flog.Debugf("path mapping %s -> %s", path, result)
+			return result, true
+		}
+	}
+	return "", false
+}
+
+//translateDevice returns the path the daemon should use for the device path.  Devices
+//are usually nodes on the docker host, like /dev/fuse, so only a mapping that contains
+//path changes it; otherwise it is used as it is, without guessing.
+func (t *pathTranslator) translateDevice(path string) string {
+	if t.mode == TRANSLATE_NONE {
+		return path
+	}
+	if result, ok := t.mapped(path); ok {
+		return result
+	}
+	return path
+}
+
+//translator returns the path translator for this configuration.  The user's configuration
+//file is consulted the first time this is called, and its settings take precedence.
+func (c *Config) translator() (*pathTranslator, error) {
+	if c.paths != nil {
+		return c.paths, nil
+	}
+	mode := c.PathTranslation
+	mappings := c.PathMappings
+	rd, err := c.helper.UserConfigReader()
+	if err != nil {
+		return nil, err
+	}
+	if rd != nil {
+		all, err := ioutil.ReadAll(rd)
+		if err != nil {
+			return nil, fmt.Errorf("could not read user configuration file: %v", err)
+		}
+		user := &UserConfig{}
+		warnings, err := decodeJSONC(readerName(rd, USER_CONFIG_FILE), all, user)
+		if err != nil {
+			return nil, fmt.Errorf("can't understand user configuration file: %v", err)
+		}
+		for _, w := range warnings {
+			flog.Warningf("%s", w)
+		}
+		if user.PathTranslation != "" {
+			mode = user.PathTranslation
+		}
+		if len(user.PathMappings) != 0 {
+			mappings = user.PathMappings
+		}
+	}
+	t, err := newPathTranslator(mode, mappings)
+	if err != nil {
+		return nil, err
+	}
+	c.paths = t
+	return t, nil
+}
+
+//translate returns the path that the docker daemon should use to see path, which is on
+//this machine.
+func (c *Config) translate(path string) (string, error) {
+	t, err := c.translator()
+	if err != nil {
+		return "", err
+	}
+	return t.translate(path)
+}
+
+//translateDevice returns the path that the docker daemon should use for the device path.
+func (c *Config) translateDevice(path string) (string, error) {
+	t, err := c.translator()
+	if err != nil {
+		return "", err
+	}
+	return t.translateDevice(path), nil
+}
@@ -0,0 +1,127 @@
+package pickett
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+	"github.com/igneous-systems/pickett/io"
+)
+
+func TestPathMappingsInOrder(t *testing.T) {
+	tr, err := newPathTranslator("", []*PathMapping{
+		&PathMapping{HostPath: "/Users/gredo/src/special", DaemonPath: "/mnt/special"},
+		&PathMapping{HostPath: "/Users/gredo", DaemonPath: "/home/docker/gredo/"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error making translator: %v", err)
+	}
+	cases := map[string]string{
+		"/Users/gredo/src/special/x": "/mnt/special/x",
+		"/Users/gredo/src/other":     "/home/docker/gredo/src/other",
+		"/Users/gredo":               "/home/docker/gredo",
+	}
+	for in, expected := range cases {
+		out, err := tr.translate(in)
+		if err != nil {
+			t.Errorf("unexpected error translating %s: %v", in, err)
+		}
+		if out != expected {
+			t.Errorf("expected %s to be translated to %s but got %s", in, expected, out)
+		}
+	}
+	if _, err := tr.translate("/Users/gredon/src"); err == nil {
+		t.Errorf("expected error for a path not covered by any mapping")
+	}
+}
+
+func TestNoPathTranslation(t *testing.T) {
+	oldHost := os.Getenv("DOCKER_HOST")
+	os.Setenv("DOCKER_HOST", "tcp://localhost:2375")
+	defer os.Setenv("DOCKER_HOST", oldHost)
+
+	tr, err := newPathTranslator("none", nil)
+	if err != nil {
+		t.Fatalf("unexpected error making translator: %v", err)
+	}
+	if out, err := tr.translate("/opt/src"); err != nil || out != "/opt/src" {
+		t.Errorf("expected no translation of /opt/src but got %s (%v)", out, err)
+	}
+	if _, err := newPathTranslator("sometimes", nil); err == nil {
+		t.Errorf("expected error for bad PathTranslation")
+	}
+	if _, err := newPathTranslator("", []*PathMapping{&PathMapping{HostPath: "src", DaemonPath: "/src"}}); err == nil {
+		t.Errorf("expected error for relative path mapping")
+	}
+}
+
+func TestUserConfigOverridesPathMappings(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+
+	helper.EXPECT().OpenDockerfileRelative("mydir").Return(nil, nil)
+	helper.EXPECT().DirectoryRelative("src").Return("/home/gredo/project/src")
+	helper.EXPECT().UserConfigReader().Return(strings.NewReader(`
+	// my laptop
+	{
+		"PathMappings" : [
+			{ "HostPath" : "/home/gredo", "DaemonPath" : "/hosthome" }
+		]
+	}`), nil)
+
+	c, err := NewConfig(strings.NewReader(example1), helper, cli, nil)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	vols, err := c.codeVolumes("someimage")
+	if err != nil {
+		t.Fatalf("unable to compute code volumes: %v", err)
+	}
+	if vols["/hosthome/project/src"] != "/han" {
+		t.Errorf("code volume not translated with user's mapping: %v", vols)
+	}
+}
+
+func TestDevicesTranslatedByMappings(t *testing.T) {
+	oldHost := os.Getenv("DOCKER_HOST")
+	os.Setenv("DOCKER_HOST", "tcp://localhost:2375")
+	defer os.Setenv("DOCKER_HOST", oldHost)
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	setupForUserConfig(helper)
+
+	c, err := NewConfig(strings.NewReader(`
+PathMappings:
+  - { HostPath: /home/gredo/images, DaemonPath: /mnt/images }
+Topologies:
+  main:
+    - Name: fuse
+      RunIn: some-image
+      Devices: { "/dev/fuse": "/dev/fuse", "/home/gredo/images/disk.img": "/dev/xvda" }
+`), helper, cli, nil)
+	if err != nil {
+		t.Fatalf("can't parse config with devices: %v", err)
+	}
+
+	//a device the mappings cover is translated, one on the docker host is left alone
+	var devices map[string]string
+	cli.EXPECT().CmdRun(gomock.Any(), "main", "0").Do(func(conf *io.RunConfig, args ...string) {
+		devices = conf.Devices
+	}).Return(nil, "", fmt.Errorf("stop here"))
+	in := &policyInput{r: c.nameToTopology["main"]["fuse"].runner}
+	if err := in.start(false, "some-image", "main", 0, nil, nil, nil, c); err == nil || err.Error() != "stop here" {
+		t.Fatalf("expected the run to be attempted, got %v", err)
+	}
+	if len(devices) != 2 || devices["/dev/fuse"] != "/dev/fuse" || devices["/mnt/images/disk.img"] != "/dev/xvda" {
+		t.Errorf("expected only the mapped device to be translated, got %v", devices)
+	}
+}
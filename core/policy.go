@@ -32,6 +32,15 @@ const (
 	CONTINUE
 )
 
+//These are the legal values of the Policy of a topology entry.  An empty Policy is FRESH.
+const (
+	POLICY_BY_HAND  = "BY_HAND"
+	POLICY_KEEP_UP  = "KEEP_UP"
+	POLICY_CONTINUE = "CONTINUE"
+	POLICY_FRESH    = "FRESH"
+	POLICY_ALWAYS   = "ALWAYS"
+)
+
 type policy struct {
 	startIfNonExistant bool
 	rebuildIfOOD       bool
@@ -50,18 +59,54 @@ func defaultPolicy() policy {
 }
 
 func formKey(key string, r runner, topoName string, instance int) string {
-	return filepath.Join(io.PICKETT_KEYSPACE, key, topoName, r.name(), fmt.Sprint(instance))
+	return instanceKey(key, topoName, r.name(), fmt.Sprint(instance))
+}
+
+//instanceKey is the etcd key for one of the records (CONTAINERS, IPS or PORTS) about an
+//instance of a topology entry.
+func instanceKey(key string, topoName string, nodeName string, instance string) string {
+	return filepath.Join(io.PICKETT_KEYSPACE, key, topoName, nodeName, instance)
+}
+
+//forgetInstance removes all the records etcd has about an instance of a topology entry.
+//Records that are not present are ignored.
+func forgetInstance(topoName string, nodeName string, instance string, etcd io.EtcdClient) error {
+	for _, kind := range []string{CONTAINERS, IPS, PORTS} {
+		key := instanceKey(kind, topoName, nodeName, instance)
+		_, present, err := etcd.Get(key)
+		if err != nil {
+			return err
+		}
+		if !present {
+			continue
+		}
+		if _, err := etcd.Del(key); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 //start runs the runner in its policyInput and records the docker container name into etcd.
 //note that this is the lowest level code that knows about the options to docker and etcd.
 //this code is the actual implementation of start.
-func (p *policyInput) start(teeOutput bool, image string, topoName string, instance int, links map[string]string, rv *runVolumeSpec, cli io.DockerCli, etcd io.EtcdClient) error {
+func (p *policyInput) start(teeOutput bool, image string, topoName string, instance int, links map[string]string, labels map[string]string, rv *runVolumeSpec, conf *Config) error {
+	cli, etcd := conf.cli, conf.etcd
 
 	vols := make(map[string]string)
 	if rv != nil {
 		vols[rv.source] = rv.mountAt
 	}
+	//devices are bound from the docker host like volumes, but most are nodes that are
+	//there already, so only a path mapping changes them
+	devs := make(map[string]string)
+	for k, v := range p.r.devices() {
+		host, err := conf.translateDevice(k)
+		if err != nil {
+			return err
+		}
+		devs[host] = v
+	}
 	runConfig := &io.RunConfig{
 		Image:      image,
 		Attach:     teeOutput,
@@ -69,8 +114,9 @@ func (p *policyInput) start(teeOutput bool, image string, topoName string, insta
 		Volumes:    vols,
 		Links:      links,
 		Ports:      p.r.exposed(),
-		Devices:    p.r.devices(),
+		Devices:    devs,
 		Privileged: p.r.privileged(),
+		Labels:     labels,
 	}
 
 	args := append(p.r.entryPoint(), topoName, fmt.Sprint(instance))
@@ -160,6 +206,7 @@ func (p policy) appyPolicy(teeOutput bool, in *policyInput, topoName string, ins
 	if err != nil {
 		return err
 	}
+	labels := conf.labels(in.r.name(), TOPOLOGY_NODE, topoName, instance, in.r.inputDigest())
 
 	//STEP1: is existing at all? All codepaths inside this branch return.
 	if !in.hasStarted {
@@ -174,7 +221,7 @@ func (p policy) appyPolicy(teeOutput bool, in *policyInput, topoName string, ins
 			}
 		}
 		flog.Debugf("policy %s, initial start of %s", p, in.r.name())
-		return in.start(teeOutput, in.r.imageName(), topoName, instance, links, rv, conf.cli, conf.etcd)
+		return in.start(teeOutput, in.r.imageName(), topoName, instance, links, labels, rv, conf)
 	}
 	//STEP2: stop?
 	if in.isRunning && ood && p.stop == FRESH {
@@ -206,7 +253,7 @@ func (p policy) appyPolicy(teeOutput bool, in *policyInput, topoName string, ins
 		if p.start == CONTINUE {
 			//this is the nasty case, need to commit the container and then continue
 			//execution from where it was
-			img, err := conf.cli.CmdCommit(in.containerName, nil)
+			img, err := conf.cli.CmdCommit(in.containerName, nil, &io.ImageConfig{Labels: labels})
 			if err != nil {
 				return err
 			}
@@ -218,7 +265,7 @@ func (p policy) appyPolicy(teeOutput bool, in *policyInput, topoName string, ins
 			startIt = true
 		}
 		if startIt {
-			if err := in.start(teeOutput, img, topoName, instance, links, rv, conf.cli, conf.etcd); err != nil {
+			if err := in.start(teeOutput, img, topoName, instance, links, labels, rv, conf); err != nil {
 				return err
 			}
 		} else {
@@ -247,8 +294,8 @@ func createPolicyInput(r runner, topoName string, instance int, conf *Config) (*
 		insp, err := conf.cli.InspectContainer(value)
 		if err != nil {
 			flog.Debugf("ignoring docker container %s that is AWOL, probably was manually killed... %s", value, err)
-			//delete the offending container
-			_, err = conf.etcd.Del(formKey(CONTAINERS, r, topoName, instance))
+			//delete the offending container, and what we knew about its network
+			err = forgetInstance(topoName, r.name(), fmt.Sprint(instance), conf.etcd)
 			if err != nil {
 				return nil, err
 			}
@@ -0,0 +1,83 @@
+package pickett
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EntryProfile changes a topology entry when its profile is chosen with --profile.  The
+// fields that are left out keep the values of the entry; an Expose or Devices that is
+// given replaces that of the entry, so {} means none.
+type EntryProfile struct {
+	// Enabled switches the entry on or off.  An Optional entry is off unless its profile
+	// switches it on.
+	Enabled    *bool
+	EntryPoint []string
+	Policy     string
+	Expose     map[string]int
+	Instances  int
+	Devices    map[string]string
+	Privileged *bool
+	WaitFor    *bool
+}
+
+//checkProfile makes sure that the chosen profile, if any, is one that some topology
+//entry has, so a misspelled profile is not silently the same as none.
+func (c *Config) checkProfile() error {
+	if c.profile == "" {
+		return nil
+	}
+	for _, entries := range c.Topologies {
+		for _, n := range entries {
+			if _, ok := profileOf(n, c.profile); ok {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no topology entry has a profile called %s", c.profile)
+}
+
+//profileOf returns the profile of n that is called name.
+func profileOf(n *TopologyEntry, name string) (*EntryProfile, bool) {
+	for k, p := range n.Profiles {
+		if strings.Trim(k, " \n") == name && p != nil {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+//profiled returns the entry n as the chosen profile has it, and whether it runs at all.
+//n itself is not changed.
+func (c *Config) profiled(n *TopologyEntry) (*TopologyEntry, bool) {
+	p, ok := profileOf(n, c.profile)
+	if c.profile == "" || !ok {
+		return n, !n.Optional
+	}
+	result := *n
+	if p.Enabled != nil {
+		result.Optional = !*p.Enabled
+	}
+	if p.EntryPoint != nil {
+		result.EntryPoint = p.EntryPoint
+	}
+	if p.Policy != "" {
+		result.Policy = p.Policy
+	}
+	if p.Expose != nil {
+		result.Expose = p.Expose
+	}
+	if p.Instances != 0 {
+		result.Instances = p.Instances
+	}
+	if p.Devices != nil {
+		result.Devices = p.Devices
+	}
+	if p.Privileged != nil {
+		result.Privileged = *p.Privileged
+	}
+	if p.WaitFor != nil {
+		result.WaitFor = *p.WaitFor
+	}
+	return &result, !result.Optional
+}
@@ -0,0 +1,93 @@
+package pickett
+
+import (
+	"strings"
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+var profileExample = `
+Containers:
+  - Repository: blah
+    Tag: bletch
+    Directory: mydir
+Topologies:
+  main:
+    - Name: web
+      RunIn: blah:bletch
+      Policy: KEEP_UP
+      Instances: 2
+      Expose: { "8080": 8080, "2345": 2345 }
+      Profiles:
+        ci: { Policy: ALWAYS, Instances: 1, Expose: {} }
+    - Name: debugger
+      RunIn: blah:bletch
+      Optional: true
+      Consumes: [ db ]
+      Profiles:
+        dev: { Enabled: true }
+    - Name: db
+      RunIn: blah:bletch
+      Profiles:
+        ci: { Enabled: false }
+`
+
+func TestProfiles(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	helper.EXPECT().OpenDockerfileRelative("mydir").Return(nil, nil).AnyTimes()
+
+	//without a profile the optional entry is off
+	c, err := NewConfigWithOptions(strings.NewReader(profileExample), helper, cli, nil, nil)
+	if err != nil {
+		t.Fatalf("can't parse config with profiles: %v", err)
+	}
+	if _, ok := c.nameToTopology["main"]["debugger"]; ok || len(c.activeEntries["main"]) != 2 {
+		t.Errorf("expected the optional entry to be off: %v", c.nameToTopology["main"])
+	}
+	web := c.nameToTopology["main"]["web"]
+	if web.instances != 2 || web.runner.(*topoRunner).policy.stop != NEVER || len(web.runner.(*topoRunner).expose) != 2 {
+		t.Errorf("expected web as written: %+v", web)
+	}
+
+	c, err = NewConfigWithOptions(strings.NewReader(profileExample), helper, cli, nil, &ConfigOptions{Profile: "dev"})
+	if err != nil {
+		t.Fatalf("can't use the dev profile: %v", err)
+	}
+	if _, ok := c.nameToTopology["main"]["debugger"]; !ok {
+		t.Errorf("expected dev to switch on the debugger: %v", c.nameToTopology["main"])
+	}
+
+	//ci switches off db, which is fine because nothing that runs consumes it
+	c, err = NewConfigWithOptions(strings.NewReader(profileExample), helper, cli, nil, &ConfigOptions{Profile: "ci"})
+	if err != nil {
+		t.Fatalf("can't use the ci profile: %v", err)
+	}
+	if len(c.Topologies["main"]) != 3 || c.Topologies["main"][0].Policy != "KEEP_UP" {
+		t.Errorf("expected the topology as written to be left alone: %+v", c.Topologies["main"])
+	}
+	web = c.nameToTopology["main"]["web"]
+	if web.instances != 1 || web.runner.(*topoRunner).policy.stop != ALWAYS || len(web.runner.(*topoRunner).expose) != 0 {
+		t.Errorf("expected ci to change web: %+v", web)
+	}
+	if _, ok := c.nameToTopology["main"]["db"]; ok {
+		t.Errorf("expected ci to switch off db: %v", c.nameToTopology["main"])
+	}
+
+	example := strings.Replace(profileExample, "dev: { Enabled: true }", "ci: { Enabled: true }", 1)
+	_, err = NewConfigWithOptions(strings.NewReader(example), helper, cli, nil, &ConfigOptions{Profile: "ci"})
+	if err == nil || err.Error() != "debugger consumes db, which is switched off (in main)" {
+		t.Errorf("expected consuming an entry that is off to fail, got %v", err)
+	}
+
+	_, err = NewConfigWithOptions(strings.NewReader(profileExample), helper, cli, nil, &ConfigOptions{Profile: "cj"})
+	if err == nil || err.Error() != "no topology entry has a profile called cj" {
+		t.Errorf("expected an unknown profile, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package pickett
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+//These are the values of the io.LABEL_TYPE label, one per kind of node.
+const (
+	CONTAINER_NODE  = "container"
+	GOBUILD_NODE    = "gobuild"
+	EXTRACTION_NODE = "extraction"
+	TOPOLOGY_NODE   = "topology"
+	GOTEST_NODE     = "gotest"
+)
+
+//provenance is the part of the labels that is the same for everything created
+//from a single configuration file.
+type provenance struct {
+	project  string
+	config   string
+	revision string
+}
+
+//provenance returns the information about this configuration that is stamped on
+//everything we create.  It is computed on first use because it requires asking
+//git about the source tree.
+func (c *Config) provenance() *provenance {
+	if c.prov == nil {
+		config := c.helper.ConfigFile()
+		c.prov = &provenance{
+			project:  filepath.Base(filepath.Dir(config)),
+			config:   config,
+			revision: c.helper.SourceRevision(),
+		}
+	}
+	return c.prov
+}
+
+//labels returns the docker labels for an object created on behalf of the node
+//named nodeName of type nodeType.  The topology and instance labels are only
+//included if topoName is not empty.
+func (c *Config) labels(nodeName string, nodeType string, topoName string, instance int, digest string) map[string]string {
+	prov := c.provenance()
+	result := map[string]string{
+		io.LABEL_PROJECT:  prov.project,
+		io.LABEL_NODE:     nodeName,
+		io.LABEL_TYPE:     nodeType,
+		io.LABEL_CONFIG:   prov.config,
+		io.LABEL_REVISION: prov.revision,
+		io.LABEL_DIGEST:   digest,
+	}
+	if topoName != "" {
+		result[io.LABEL_TOPOLOGY] = topoName
+		result[io.LABEL_INSTANCE] = fmt.Sprint(instance)
+	}
+	return result
+}
+
+//inputDigest returns a digest of the declaration of a node in the configuration
+//file, so that two objects can be compared to see if they were produced from
+//the same inputs.
+func inputDigest(decl interface{}) string {
+	buf, err := json.Marshal(decl)
+	if err != nil {
+		//can't happen, these were all read from json in the first place
+		panic(fmt.Sprintf("unable to compute digest of %+v: %v", decl, err))
+	}
+	return fmt.Sprintf("%x", sha1.Sum(buf))
+}
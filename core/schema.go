@@ -0,0 +1,141 @@
+package pickett
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+//SCHEMA_DRAFT is the version of JSON Schema that Schema writes.
+const SCHEMA_DRAFT = "http://json-schema.org/draft-07/schema#"
+
+//enums are the legal values of the fields that have a fixed set of them, by type and
+//field.  Case and surrounding spaces don't matter to pickett and an empty value is the
+//default.
+var enums = map[string][]string{
+	"TopologyEntry.Policy":   {POLICY_BY_HAND, POLICY_KEEP_UP, POLICY_CONTINUE, POLICY_FRESH, POLICY_ALWAYS},
+	"EntryProfile.Policy":    {POLICY_BY_HAND, POLICY_KEEP_UP, POLICY_CONTINUE, POLICY_FRESH, POLICY_ALWAYS},
+	"Config.PathTranslation": {TRANSLATE_AUTO, TRANSLATE_NONE},
+	"CodeVolume.Mode":        {CODE_VOLUME_BIND, CODE_VOLUME_COPY},
+	"GoTest.Format":          {TEST_FORMAT_JSON, TEST_FORMAT_JUNIT},
+}
+
+//wholeReference is a string that is nothing but a reference to a variable, which may
+//stand for a number or a boolean.
+const wholeReference = `^\$\{[^{}]*\}$`
+
+// Schema returns a JSON Schema of the configuration file, made from the types it is
+// decoded into, so editors can complete it and configurations can be checked without
+// docker or etcd.
+func Schema() ([]byte, error) {
+	definitions := make(map[string]interface{})
+	result := structSchema(reflect.TypeOf(Config{}), definitions)
+	result["$schema"] = SCHEMA_DRAFT
+	result["title"] = "Pickett configuration"
+	result["definitions"] = definitions
+	return json.MarshalIndent(result, "", "\t")
+}
+
+//structSchema returns the schema of an object decoded into the struct t.  The schemas of
+//the structs it holds are put in definitions.
+func structSchema(t reflect.Type, definitions map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	patterns := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		s := typeSchema(f.Type, definitions)
+		if values, ok := enums[t.Name()+"."+f.Name]; ok {
+			s = orReference(map[string]interface{}{"type": "string", "pattern": enumPattern(values), "examples": values})
+		}
+		//the properties are for editors to suggest, but keys are matched whatever their case
+		properties[f.Name] = s
+		patterns["^"+caseless(f.Name)+"$"] = s
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"patternProperties":    patterns,
+		"additionalProperties": false,
+	}
+}
+
+//typeSchema returns the schema of a value decoded into t.
+func typeSchema(t reflect.Type, definitions map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return orReference(map[string]interface{}{"type": "boolean"})
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return orReference(map[string]interface{}{"type": "integer"})
+	case reflect.Float32, reflect.Float64:
+		return orReference(map[string]interface{}{"type": "number"})
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), definitions)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), definitions)}
+	case reflect.Struct:
+		if _, ok := definitions[t.Name()]; !ok {
+			//in case t holds itself
+			definitions[t.Name()] = true
+			definitions[t.Name()] = structSchema(t, definitions)
+		}
+		return map[string]interface{}{"$ref": fmt.Sprintf("#/definitions/%s", t.Name())}
+	}
+	return map[string]interface{}{}
+}
+
+//orReference is the schema of a value that s allows, or of a reference to a variable
+//that gives it.
+func orReference(s map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"anyOf": []interface{}{
+			s,
+			map[string]interface{}{"type": "string", "pattern": wholeReference},
+		},
+	}
+}
+
+//enumPattern returns a pattern that matches any of the values, or nothing, in any case
+//and with spaces and newlines around it.
+func enumPattern(values []string) string {
+	alternatives := []string{}
+	for _, v := range values {
+		alternatives = append(alternatives, caseless(v))
+	}
+	return `^[ \n]*(` + strings.Join(alternatives, "|") + `)?[ \n]*$`
+}
+
+//caseless returns a pattern that matches s in any case.  Patterns in JSON Schema have no
+//flag for this.
+func caseless(s string) string {
+	result := ""
+	for _, r := range s {
+		lower, upper := strings.ToLower(string(r)), strings.ToUpper(string(r))
+		if lower == upper {
+			result += regexp.QuoteMeta(string(r))
+		} else {
+			result += "[" + lower + upper + "]"
+		}
+	}
+	return result
+}
+
+// CmdSchema prints the JSON Schema of the configuration file.
+func CmdSchema() error {
+	out, err := Schema()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", out)
+	return nil
+}
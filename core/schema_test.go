@@ -0,0 +1,71 @@
+package pickett
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var caselessExample = `
+topologies:
+  main:
+    - name: web
+      runIn: web:latest
+      policy: " Keep_Up"
+`
+
+func TestSchema(t *testing.T) {
+	out, err := Schema()
+	if err != nil {
+		t.Fatalf("can't make schema: %v", err)
+	}
+	var schema struct {
+		Properties  map[string]map[string]interface{}
+		Definitions map[string]struct {
+			Properties           map[string]map[string]interface{}
+			PatternProperties    map[string]interface{}
+			AdditionalProperties bool
+		}
+	}
+	if err := json.Unmarshal(out, &schema); err != nil {
+		t.Fatalf("schema is not JSON: %v", err)
+	}
+	for _, name := range []string{"Container", "GoBuild", "GenericBuild", "Extraction", "TopologyEntry", "BuildOpts"} {
+		def, ok := schema.Definitions[name]
+		if !ok || def.AdditionalProperties || len(def.Properties) == 0 {
+			t.Errorf("expected a closed definition of %s: %+v", name, def)
+		}
+	}
+	if schema.Properties["Containers"]["items"].(map[string]interface{})["$ref"] != "#/definitions/Container" {
+		t.Errorf("expected Containers to refer to Container: %v", schema.Properties["Containers"])
+	}
+
+	entry := schema.Definitions["TopologyEntry"].Properties
+	policy := entry["Policy"]["anyOf"].([]interface{})[0].(map[string]interface{})
+	pattern := regexp.MustCompile(policy["pattern"].(string))
+	for _, v := range []string{"", POLICY_KEEP_UP, "Keep_Up", " keep_up\n"} {
+		if !pattern.MatchString(v) {
+			t.Errorf("expected the policy %q to be allowed by %s", v, pattern)
+		}
+	}
+	if pattern.MatchString("KEEP_DOWN") {
+		t.Errorf("expected an unknown policy not to be allowed by %s", pattern)
+	}
+
+	//keys are matched whatever their case, as pickett does
+	matched := false
+	for p := range schema.Definitions["Container"].PatternProperties {
+		matched = matched || regexp.MustCompile(p).MatchString("repository")
+	}
+	if !matched {
+		t.Errorf("expected a lowercase key to be allowed: %v", schema.Definitions["Container"].PatternProperties)
+	}
+	if _, err := NewConfig(strings.NewReader(caselessExample), nil, nil, nil); err != nil {
+		t.Errorf("expected pickett to accept what the schema does: %v", err)
+	}
+	instances := entry["Instances"]["anyOf"].([]interface{})
+	if instances[0].(map[string]interface{})["type"] != "integer" || instances[1].(map[string]interface{})["pattern"] != wholeReference {
+		t.Errorf("expected Instances to be a number or a variable: %v", instances)
+	}
+}
@@ -0,0 +1,304 @@
+package pickett
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//shownConfig is the configuration as pickett understands it once it has been read: with
+//the defaults applied, directories made absolute, the profile applied and the edges of
+//the dependency graph worked out.  Lists are in a stable order, so two of these can be
+//compared.
+type shownConfig struct {
+	Variables       map[string]string
+	Profile         string `json:",omitempty"`
+	PathTranslation string
+	PathMappings    []*PathMapping
+	CodeVolumes     []*shownCodeVolume
+	Containers      []*shownContainer
+	GoBuilds        []*shownGoBuild
+	GoTests         []*shownGoTest
+	Extractions     []*shownExtraction
+	GenericBuilds   []*GenericBuild
+	Topologies      map[string][]*shownEntry
+	Edges           map[string][]string
+	ExternalImages  []string
+}
+
+type shownCodeVolume struct {
+	Directory        string
+	DaemonDirectory  string `json:",omitempty"`
+	TranslationError string `json:",omitempty"`
+	MountedAt        string
+	Mode             string
+}
+
+type shownContainer struct {
+	Name      string
+	Directory string
+	BuildArgs map[string]string
+	Template  bool
+	DependsOn []string
+	Inferred  []string
+}
+
+type shownGoBuild struct {
+	Name      string
+	RunIn     string
+	Packages  []string
+	Command   string
+	Probe     string `json:",omitempty"`
+	TestFile  string `json:",omitempty"`
+	ModuleDir string `json:",omitempty"`
+	Outputs   map[string]string
+	Env       []string
+	Parallel  bool
+}
+
+type shownGoTest struct {
+	Name      string
+	RunIn     string
+	Packages  []string
+	ModuleDir string `json:",omitempty"`
+	Command   string
+	Format    string
+	ReportDir string `json:",omitempty"`
+}
+
+type shownExtraction struct {
+	Name      string
+	RunIn     string
+	MergeWith string
+	Artifacts []*Artifact
+}
+
+type shownEntry struct {
+	Name       string
+	RunIn      string
+	EntryPoint []string
+	Consumes   []string
+	Instances  int
+	Policy     *shownPolicy
+	Expose     map[string][]string
+	Devices    map[string]string
+	Privileged bool
+	WaitFor    bool
+}
+
+//shownPolicy is what a Policy means.
+type shownPolicy struct {
+	Name               string
+	StartIfNonExistent bool
+	RebuildIfOutOfDate bool
+	Start              string
+	Stop               string
+}
+
+// CmdConfigShow prints the configuration as JSON.  Normally this is the configuration
+// as pickett understands it, with defaults, absolute directories, translated paths,
+// policies and the edges between the nodes.  If raw, it is the configuration as
+// written, once the variables have been replaced.  The Variables are shown with the
+// values they ended up with either way.
+func CmdConfigShow(config *Config, raw bool) error {
+	var shown interface{}
+	if raw {
+		written := *config
+		if config.variables != nil {
+			written.Variables = config.variables.effective()
+		}
+		shown = &written
+	} else {
+		shown = config.normalized()
+	}
+	out, err := json.MarshalIndent(shown, "", "\t")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", out)
+	return nil
+}
+
+//normalized returns the configuration as pickett understands it.
+func (c *Config) normalized() *shownConfig {
+	result := &shownConfig{
+		Variables:     make(map[string]string),
+		Profile:       c.profile,
+		GenericBuilds: c.GenericBuilds,
+		Topologies:    make(map[string][]*shownEntry),
+		Edges:         make(map[string][]string),
+	}
+	if c.variables != nil {
+		result.Variables = c.variables.effective()
+	}
+	var translationErr error
+	if t, err := c.translator(); err == nil {
+		result.PathTranslation = t.mode
+		result.PathMappings = t.mappings
+	} else {
+		translationErr = err
+	}
+	for _, v := range c.CodeVolumes {
+		shown := &shownCodeVolume{
+			Directory: c.helper.DirectoryRelative(v.Directory),
+			MountedAt: v.MountedAt,
+			Mode:      v.Mode,
+		}
+		err := translationErr
+		if err == nil {
+			shown.DaemonDirectory, err = c.translate(shown.Directory)
+		}
+		if err != nil {
+			shown.TranslationError = err.Error()
+		}
+		result.CodeVolumes = append(result.CodeVolumes, shown)
+	}
+
+	external := make(map[string]bool)
+	names := []string{}
+	for name := range c.nameToNode {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		inputs := []string{}
+		for _, in := range c.nameToNode[name].implementation().in() {
+			if in != nil {
+				inputs = append(inputs, in.name())
+			}
+		}
+		switch b := c.nameToNode[name].implementation().(type) {
+		case *containerBuilder:
+			declared := []string{}
+			for _, img := range c.Containers {
+				if strings.Trim(img.Repository, " \n")+":"+strings.Trim(img.Tag, " \n") == name {
+					declared = img.DependsOn
+				}
+			}
+			result.Containers = append(result.Containers, &shownContainer{
+				Name:      name,
+				Directory: c.helper.DirectoryRelative(b.dir),
+				BuildArgs: b.buildArgs,
+				Template:  b.template,
+				DependsOn: declared,
+				Inferred:  b.inferred,
+			})
+		case *goBuilder:
+			shown := &shownGoBuild{
+				Name:     name,
+				RunIn:    b.runIn.name(),
+				Packages: b.pkgs,
+				Command:  b.command,
+				Outputs:  b.outputs,
+				Env:      b.env,
+				Parallel: b.parallel,
+			}
+			if b.moduleDir != "" {
+				shown.ModuleDir = c.helper.DirectoryRelative(b.moduleDir)
+			} else {
+				shown.Probe = b.probe
+				shown.TestFile = b.testFile
+			}
+			result.GoBuilds = append(result.GoBuilds, shown)
+		case *goTester:
+			shown := &shownGoTest{
+				Name:     name,
+				RunIn:    b.runIn.name(),
+				Packages: b.pkgs,
+				Command:  b.command,
+				Format:   b.format,
+			}
+			if b.moduleDir != "" {
+				shown.ModuleDir = c.helper.DirectoryRelative(b.moduleDir)
+			}
+			if b.reportDir != "" {
+				shown.ReportDir = c.helper.DirectoryRelative(b.reportDir)
+			}
+			result.GoTests = append(result.GoTests, shown)
+		case *extractionBuilder:
+			for _, n := range []nodeOrName{b.runIn, b.mergeWith} {
+				if !n.isNode {
+					external[n.name] = true
+					inputs = append(inputs, n.name)
+				}
+			}
+			result.Extractions = append(result.Extractions, &shownExtraction{
+				Name:      name,
+				RunIn:     b.runIn.name,
+				MergeWith: b.mergeWith.name,
+				Artifacts: b.artifacts,
+			})
+		}
+		sort.Strings(inputs)
+		result.Edges[name] = inputs
+	}
+
+	for topo, entries := range c.activeEntries {
+		t := strings.Trim(topo, " \n")
+		result.Topologies[t] = []*shownEntry{}
+		for _, e := range entries {
+			info, ok := c.nameToTopology[t][strings.Trim(e.Name, " \n")]
+			if !ok {
+				continue
+			}
+			r := info.runner.(*topoRunner)
+			name := t + "." + r.name()
+			shown := &shownEntry{
+				Name:       r.name(),
+				RunIn:      r.runIn.name,
+				EntryPoint: r.entry,
+				Consumes:   []string{},
+				Instances:  info.instances,
+				Policy:     showPolicy(e.Policy, r.policy),
+				Expose:     make(map[string][]string),
+				Devices:    r.devs,
+				Privileged: r.priv,
+				WaitFor:    r.wait,
+			}
+			for port, bindings := range r.expose {
+				for _, b := range bindings {
+					shown.Expose[string(port)] = append(shown.Expose[string(port)], b.HostIp+":"+b.HostPort)
+				}
+			}
+			inputs := []string{r.runIn.name}
+			if !r.runIn.isNode {
+				external[r.runIn.name] = true
+			}
+			for _, other := range r.consumes {
+				consumed := other.topology() + "." + other.name()
+				if other.topology() == t {
+					shown.Consumes = append(shown.Consumes, other.name())
+				} else {
+					shown.Consumes = append(shown.Consumes, consumed)
+				}
+				inputs = append(inputs, consumed)
+			}
+			sort.Strings(inputs)
+			result.Edges[name] = inputs
+			result.Topologies[t] = append(result.Topologies[t], shown)
+		}
+	}
+
+	result.ExternalImages = []string{}
+	for image := range external {
+		result.ExternalImages = append(result.ExternalImages, image)
+	}
+	sort.Strings(result.ExternalImages)
+	return result
+}
+
+//showPolicy says what the Policy name became.
+func showPolicy(name string, p policy) *shownPolicy {
+	name = strings.ToUpper(strings.Trim(name, " \n"))
+	if name == "" {
+		name = POLICY_FRESH
+	}
+	return &shownPolicy{
+		Name:               name,
+		StartIfNonExistent: p.startIfNonExistant,
+		RebuildIfOutOfDate: p.rebuildIfOOD,
+		Start:              p.start.String(),
+		Stop:               p.stop.String(),
+	}
+}
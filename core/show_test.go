@@ -0,0 +1,80 @@
+package pickett
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+var showExample = `
+PathTranslation: none
+CodeVolumes:
+  - { Directory: src, MountedAt: /han }
+Containers:
+  - { Repository: netexample, Tag: part1, Directory: somedir }
+GoBuilds:
+  - { Repository: netexample, Tag: uses-part1, RunIn: "netexample:part1", Packages: [ p1 ] }
+Topologies:
+  main:
+    - { Name: db, RunIn: "postgres:9" }
+    - { Name: web, RunIn: "netexample:uses-part1", Consumes: [ db ], Policy: keep_up, Instances: 0, Expose: { "80": 8080 } }
+`
+
+func TestConfigNormalized(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	helper.EXPECT().OpenDockerfileRelative("somedir").Return(nil, nil)
+	helper.EXPECT().DirectoryRelative("somedir").Return("/proj/somedir").AnyTimes()
+	helper.EXPECT().DirectoryRelative("src").Return("/proj/src").AnyTimes()
+	setupForUserConfig(helper)
+
+	c, err := NewConfig(strings.NewReader(showExample), helper, nil, nil)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	shown := c.normalized()
+	if shown.PathTranslation != TRANSLATE_NONE {
+		t.Errorf("expected the path translation mode: %s", shown.PathTranslation)
+	}
+	v := shown.CodeVolumes[0]
+	if v.Directory != "/proj/src" || v.DaemonDirectory != "/proj/src" || v.Mode != CODE_VOLUME_BIND {
+		t.Errorf("expected an absolute, translated code volume: %+v", v)
+	}
+	if shown.Containers[0].Directory != "/proj/somedir" {
+		t.Errorf("expected an absolute directory: %+v", shown.Containers[0])
+	}
+	b := shown.GoBuilds[0]
+	if b.Command != "go install" || b.Probe != "go install -n" || b.RunIn != "netexample:part1" {
+		t.Errorf("expected the defaults of a go build: %+v", b)
+	}
+
+	db, web := shown.Topologies["main"][0], shown.Topologies["main"][1]
+	if db.Policy.Name != POLICY_FRESH || db.Policy.Stop != "FRESH" || !db.Policy.StartIfNonExistent {
+		t.Errorf("expected an empty policy to be FRESH: %+v", db.Policy)
+	}
+	if web.Policy.Name != POLICY_KEEP_UP || web.Policy.Stop != "NEVER" || web.Instances != 1 {
+		t.Errorf("expected KEEP_UP and one instance: %+v %+v", web, web.Policy)
+	}
+	if !reflect.DeepEqual(web.Expose, map[string][]string{"80": {"0.0.0.0:8080"}}) {
+		t.Errorf("unexpected ports: %v", web.Expose)
+	}
+
+	edges := map[string][]string{
+		"netexample:part1":      {},
+		"netexample:uses-part1": {"netexample:part1"},
+		"main.db":               {"postgres:9"},
+		"main.web":              {"main.db", "netexample:uses-part1"},
+	}
+	if !reflect.DeepEqual(shown.Edges, edges) {
+		t.Errorf("unexpected edges: %v", shown.Edges)
+	}
+	if !reflect.DeepEqual(shown.ExternalImages, []string{"postgres:9"}) {
+		t.Errorf("unexpected external images: %v", shown.ExternalImages)
+	}
+}
@@ -1,6 +1,8 @@
 package pickett
 
 import (
+	"fmt"
+
 	"github.com/igneous-systems/pickett/io"
 )
 
@@ -16,6 +18,7 @@ type nodeOrName struct {
 //topo runner is single node in a topology
 type topoRunner struct {
 	n             string
+	topo          string
 	runIn         nodeOrName
 	entry         []string
 	consumes      []runner
@@ -25,12 +28,17 @@ type topoRunner struct {
 	devs          map[string]string
 	priv          bool
 	wait          bool
+	digest        string
 }
 
 func (n *topoRunner) name() string {
 	return n.n
 }
 
+func (n *topoRunner) topology() string {
+	return n.topo
+}
+
 func (n *topoRunner) exposed() map[io.Port][]io.PortBinding {
 	return n.expose
 }
@@ -55,6 +63,10 @@ func (n *topoRunner) contName() string {
 	return n.containerName
 }
 
+func (n *topoRunner) inputDigest() string {
+	return n.digest
+}
+
 //in returns a single node that is our inbound edge, the container we run in.
 func (n *topoRunner) in() []node {
 	result := []node{}
@@ -71,12 +83,17 @@ func (n *topoRunner) imageName() string {
 
 // run actually does the work to launch this network ,including launching all the networks
 // that this one depends on (consumes).  Note that behavior of starting or stopping
-// particular dependent services is controllled through the policy apparatus.
+// particular dependent services is controllled through the policy apparatus.  The
+// networks consumed are run in their own topology, so one that is shared between
+// topologies is started once and then found running by the others.
 func (n *topoRunner) run(teeOutput bool, conf *Config, topoName string, instance int, rv *runVolumeSpec) (*policyInput, error) {
+	if !conf.imageExists(n.runIn.name) {
+		return nil, fmt.Errorf("unable to find image '%s' to run (network) %s in!", n.runIn.name, n.name())
+	}
 	links := make(map[string]string)
 	for _, r := range n.consumes {
-		flog.Debugf("launching %s because %s consumes it (only launching one instance)", r.name(), n.name())
-		input, err := r.run(false, conf, topoName, 0, rv)
+		flog.Debugf("launching %s.%s because %s consumes it (only launching one instance)", r.topology(), r.name(), n.name())
+		input, err := r.run(false, conf, r.topology(), 0, rv)
 		if err != nil {
 			return nil, err
 		}
@@ -76,9 +76,11 @@ func TestMultipleInstances(T *testing.T) {
 
 	//called as part of config check
 	helper.EXPECT().OpenDockerfileRelative("somedir").Return(nil, nil)
+	setupForLabels(helper)
+	setupForUserConfig(helper)
 	helper.EXPECT().LastTimeInDirRelative("somedir").Return(oneHrAgoOneMin, nil).AnyTimes() //why?
 
-	//image name for these is checked in the config parsing, we act as though they exists
+	//image name for these is checked when they are run, we act as though they exists
 	cli.EXPECT().InspectImage("part3-image").Return(ignoredInspect, nil)
 	cli.EXPECT().InspectImage("part4-image").Return(ignoredInspect, nil)
 
@@ -140,3 +142,105 @@ func TestMultipleInstances(T *testing.T) {
 	}
 
 }
+
+var sharedExample = `
+Topologies:
+  infra:
+    - Name: db
+      RunIn: db-image
+      Policy: KEEP_UP
+  app1:
+    - Name: web
+      RunIn: web-image
+      Policy: BY_HAND
+      Consumes: [ infra.db ]
+  app2:
+    - Name: web
+      RunIn: web-image
+      Policy: BY_HAND
+      Consumes: [ infra.db ]
+`
+
+func TestSharedEntries(T *testing.T) {
+	controller := gomock.NewController(T)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+	setupForLabels(helper)
+	setupForUserConfig(helper)
+
+	c, err := NewConfig(strings.NewReader(sharedExample), helper, cli, etcd)
+	if err != nil {
+		T.Fatalf("can't parse config with shared entries: %v", err)
+	}
+	db := c.nameToTopology["infra"]["db"].runner
+	for _, app := range []string{"app1", "app2"} {
+		consumes := c.nameToTopology[app]["web"].runner.(*topoRunner).consumes
+		if len(consumes) != 1 || consumes[0] != db || consumes[0].topology() != "infra" {
+			T.Errorf("expected %s.web to consume infra.db: %+v", app, consumes)
+		}
+	}
+
+	//the db is kept under infra, so both webs find the one that is running
+	ignoredInspect := io.NewMockInspectedImage(controller)
+	cli.EXPECT().InspectImage("web-image").Return(ignoredInspect, nil)
+	cli.EXPECT().InspectImage("db-image").Return(ignoredInspect, nil)
+	DB := "/pickett/" + CONTAINERS + "/infra/db/0"
+	dbCont := io.NewMockInspectedContainer(controller)
+	etcd.EXPECT().Get(DB).Return("shared_db", true, nil).Times(2)
+	cli.EXPECT().InspectContainer("shared_db").Return(dbCont, nil).Times(2)
+	dbCont.EXPECT().Running().Return(true).Times(2)
+	dbCont.EXPECT().CreatedTime().Return(time.Now()).Times(2)
+	dbCont.EXPECT().ContainerName().Return("shared_db").Times(2)
+	etcd.EXPECT().Get("/pickett/"+CONTAINERS+"/app1/web/0").Return("", false, nil)
+	etcd.EXPECT().Get("/pickett/"+CONTAINERS+"/app2/web/0").Return("", false, nil)
+	for _, target := range []string{"app1.web", "app2.web"} {
+		if _, err := c.Execute(target, nil); err != nil {
+			T.Fatalf("error running %s: %v", target, err)
+		}
+	}
+
+	//stopping app1 and infra leaves the db to app2, which is running
+	APP2 := "/pickett/" + CONTAINERS + "/app2"
+	webCont := io.NewMockInspectedContainer(controller)
+	etcd.EXPECT().Children("/pickett/"+CONTAINERS).Return([]string{"app2", "infra"}, true, nil)
+	etcd.EXPECT().Children(APP2).Return([]string{"web"}, true, nil)
+	etcd.EXPECT().Children(APP2+"/web").Return([]string{"0"}, true, nil)
+	etcd.EXPECT().Get(APP2+"/web/0").Return("app2_web", true, nil)
+	cli.EXPECT().InspectContainer("app2_web").Return(webCont, nil)
+	webCont.EXPECT().Running().Return(true)
+	stop, err := spareConsumed(c, []string{"app1.web", "infra.db"})
+	if err != nil {
+		T.Fatalf("can't work out what to stop: %v", err)
+	}
+	if len(stop) != 1 || stop[0] != "app1.web" {
+		T.Errorf("expected only app1.web to be stopped, got %v", stop)
+	}
+
+	//stopping everything stops the db too, without asking about app2
+	stop, err = spareConsumed(c, []string{"app1.web", "app2.web", "infra.db"})
+	if err != nil || len(stop) != 3 {
+		T.Errorf("expected everything to be stopped, got %v (%v)", stop, err)
+	}
+
+	example := strings.Replace(sharedExample, "[ infra.db ]", "[ infra.cache ]", 1)
+	_, err = NewConfig(strings.NewReader(example), helper, cli, etcd)
+	if err == nil || err.Error() != "can't find other topo node named infra.cache for web (in app1)" {
+		T.Errorf("expected an unknown shared entry to fail, got %v", err)
+	}
+
+	//the . is what separates the topology from the node
+	example = strings.Replace(sharedExample, "  app2:", "  app.2:", 1)
+	_, err = NewConfig(strings.NewReader(example), helper, cli, etcd)
+	if err == nil || err.Error() != "topology named app.2 can't have a . in it" {
+		T.Errorf("expected a topology with a . in its name to fail, got %v", err)
+	}
+
+	example = strings.Replace(sharedExample, "Policy: KEEP_UP", "Policy: KEEP_UP\n      Consumes: [ app1.web ]", 1)
+	_, err = NewConfig(strings.NewReader(example), helper, cli, etcd)
+	if err == nil || err.Error() != "topology nodes consume each other: app1.web -> infra.db -> app1.web" {
+		T.Errorf("expected entries consuming each other to fail, got %v", err)
+	}
+}
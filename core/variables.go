@@ -0,0 +1,235 @@
+package pickett
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigOptions change how a configuration file is read.
+type ConfigOptions struct {
+	// VariablesFile, if not nil, holds an object of variable names and their values, in
+	// any of the formats of a configuration file.  These win over the environment.
+	VariablesFile io.Reader
+	// Set gives variables as name=value, like --set.  These win over everything else.
+	Set []string
+	// Overlays change the entries of the topologies, in order.  Each holds Topologies
+	// whose entries are matched to those of the configuration by Name.
+	Overlays []io.Reader
+	// Profile is the name of the profile of the topology entries to use, like --profile.
+	Profile string
+}
+
+//varPattern is a reference to a variable, ${NAME}.  $${ is a way to write ${ that isn't
+//a reference.
+var varPattern = regexp.MustCompile(`\$\$\{|\$\{([^{}]*)\}`)
+
+//interpolated are the sections of the configuration whose strings may refer to variables.
+var interpolated = []string{"Containers", "GoBuilds", "GoTests", "Extractions", "Topologies"}
+
+//variables are what references to variables are replaced with.  From the weakest to the
+//strongest, values come from the Variables of the configuration, the environment, the
+//variables file and the names set on the command line.
+type variables struct {
+	defaults  map[string]string
+	file      map[string]string
+	set       map[string]string
+	used      map[string]bool
+	expanding map[string]bool
+}
+
+//newVariables collects the variables for the configuration doc, which came from file.
+func newVariables(file string, doc *yaml.Node, options *ConfigOptions) (*variables, error) {
+	result := &variables{
+		defaults:  make(map[string]string),
+		file:      make(map[string]string),
+		set:       make(map[string]string),
+		used:      make(map[string]bool),
+		expanding: make(map[string]bool),
+	}
+	if doc.Kind == yaml.MappingNode {
+		for _, pair := range mappingPairs(doc) {
+			if f, ok := fieldForKey(reflect.TypeOf(Config{}), pair[0].Value); !ok || f.Name != "Variables" {
+				continue
+			}
+			if _, err := decodeDocument(file, pair[1], nil, &result.defaults); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if options == nil {
+		return result, nil
+	}
+	if options.VariablesFile != nil {
+		content, err := ioutil.ReadAll(options.VariablesFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read all of variables file: %v", err)
+		}
+		if _, err := decodeConfig(readerName(options.VariablesFile, ""), content, &result.file); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range options.Set {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("can't set %s, expected name=value", s)
+		}
+		result.set[strings.TrimSpace(parts[0])] = parts[1]
+	}
+	return result, nil
+}
+
+//lookup returns the value of the variable name, without expanding it.
+func (v *variables) lookup(name string) (string, bool) {
+	if value, ok := v.set[name]; ok {
+		return value, true
+	}
+	if value, ok := v.file[name]; ok {
+		return value, true
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true
+	}
+	value, ok := v.defaults[name]
+	return value, ok
+}
+
+//value returns the value of the variable name.  Values may refer to other variables.
+func (v *variables) value(name string) (string, error) {
+	value, ok := v.lookup(name)
+	if !ok {
+		return "", fmt.Errorf("no variable called %s", name)
+	}
+	if v.expanding[name] {
+		return "", fmt.Errorf("variable %s refers to itself", name)
+	}
+	v.used[name] = true
+	v.expanding[name] = true
+	defer delete(v.expanding, name)
+	return v.expand(value)
+}
+
+//expand replaces the references to variables in s with their values.
+func (v *variables) expand(s string) (string, error) {
+	return expandReferences(s, v.value)
+}
+
+//expandReferences replaces each ${ref} in s with what value returns for it, and each $${
+//with ${.  It returns the first error of value.
+func expandReferences(s string, value func(ref string) (string, error)) (string, error) {
+	var firstErr error
+	result := varPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		expanded, err := value(strings.TrimSpace(varPattern.FindStringSubmatch(match)[1]))
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		return expanded
+	})
+	return result, firstErr
+}
+
+//effective returns the value of every variable that is defined or used.
+func (v *variables) effective() map[string]string {
+	result := make(map[string]string)
+	names := make(map[string]bool)
+	for _, m := range []map[string]string{v.defaults, v.file, v.set} {
+		for name := range m {
+			names[name] = true
+		}
+	}
+	for name := range v.used {
+		names[name] = true
+	}
+	for name := range names {
+		if value, err := v.value(name); err == nil {
+			result[name] = value
+		}
+	}
+	return result
+}
+
+//interpolateConfig replaces the references in the sections of the configuration doc,
+//which came from file and the other files in from, that may have them.
+func (v *variables) interpolateConfig(file string, doc *yaml.Node, from sources) error {
+	if doc.Kind != yaml.MappingNode {
+		return nil
+	}
+	for _, pair := range mappingPairs(doc) {
+		f, ok := fieldForKey(reflect.TypeOf(Config{}), pair[0].Value)
+		if !ok || !contains(interpolated, f.Name) {
+			continue
+		}
+		if err := v.interpolate(from, file, pair[1], f.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//interpolate replaces the references in the strings of n, which is decoded into t.  A
+//string that is nothing but a reference can be used for a number or a boolean.
+func (v *variables) interpolate(from sources, file string, n *yaml.Node, t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	file = from.file(n, file)
+	switch n = unalias(n); n.Kind {
+	case yaml.MappingNode:
+		for _, pair := range mappingPairs(n) {
+			var elem reflect.Type
+			switch t.Kind() {
+			case reflect.Map:
+				if err := v.interpolate(from, file, pair[0], t.Key()); err != nil {
+					return err
+				}
+				elem = t.Elem()
+			case reflect.Struct:
+				f, ok := fieldForKey(t, pair[0].Value)
+				if !ok || (t == reflect.TypeOf(Container{}) && f.Name == "BuildArgs") {
+					//these may name nodes, so they are expanded with the Dockerfile
+					continue
+				}
+				elem = f.Type
+			default:
+				continue
+			}
+			if err := v.interpolate(from, file, pair[1], elem); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return nil
+		}
+		for _, elem := range n.Content {
+			if err := v.interpolate(from, file, elem, t.Elem()); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if n.ShortTag() != "!!str" || !strings.Contains(n.Value, "${") {
+			return nil
+		}
+		ref := varPattern.FindStringIndex(n.Value)
+		whole := ref != nil && ref[0] == 0 && ref[1] == len(n.Value)
+		value, err := v.expand(n.Value)
+		if err != nil {
+			return &ConfigError{File: file, Line: n.Line, Column: n.Column, Msg: err.Error()}
+		}
+		n.Value = value
+		if whole && t.Kind() != reflect.String {
+			//let the value decide what it is, like it would if it were written in place
+			n.Tag, n.Style = "", 0
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package pickett
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+	"github.com/igneous-systems/pickett/io"
+)
+
+var variablesExample = `{
+	"Variables" : {
+		"Registry" : "registry.example.com:5000",
+		"Repo" : "${Registry}/blah",
+		"Tag" : "dev",
+		"Instances" : "1",
+		"Port" : "8080"
+	},
+	"Containers" : [
+		{ "Repository": "${Repo}", "Tag" : "${Tag}", "Directory" : "mydir", "Comment" : "${Unknown}" }
+	],
+	"Topologies" : {
+		"main" : [
+			{
+				"Name" : "web",
+				"RunIn" : "${Repo}:${Tag}",
+				"EntryPoint" : [ "echo", "$${HOME} is not replaced" ],
+				"Instances" : "${Instances}",
+				"Expose" : { "${Port}" : "${Port}" }
+			}
+		]
+	}
+}`
+
+func TestVariablesInterpolated(t *testing.T) {
+	os.Setenv("PICKETT_TEST_TAG", "from-env")
+	defer os.Unsetenv("PICKETT_TEST_TAG")
+	example := strings.Replace(variablesExample, `"Tag" : "dev"`, `"Tag" : "${PICKETT_TEST_TAG}"`, 1)
+
+	options := &ConfigOptions{
+		VariablesFile: strings.NewReader("Instances: '3'\nPort: '9090'\n"),
+		Set:           []string{"Port=7070"},
+	}
+	doc, file, err := parseConfig("", []byte(example))
+	if err != nil {
+		t.Fatalf("can't parse: %v", err)
+	}
+	vars, err := newVariables(file, doc, options)
+	if err != nil {
+		t.Fatalf("can't read variables: %v", err)
+	}
+	if err := vars.interpolateConfig(file, doc, nil); err != nil {
+		t.Fatalf("can't replace variables: %v", err)
+	}
+	conf := &Config{}
+	warnings, err := decodeDocument(file, doc, nil, conf)
+	if err != nil {
+		t.Fatalf("can't decode: %v", err)
+	}
+	//unknown keys are not interpolated
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "Comment") {
+		t.Errorf("expected only a warning about Comment: %v", warnings)
+	}
+	c := conf.Containers[0]
+	if c.Repository != "registry.example.com:5000/blah" || c.Tag != "from-env" {
+		t.Errorf("variables not replaced in container: %+v", c)
+	}
+	web := conf.Topologies["main"][0]
+	if web.RunIn != "registry.example.com:5000/blah:from-env" {
+		t.Errorf("variables not replaced in RunIn: %s", web.RunIn)
+	}
+	if web.EntryPoint[1] != "${HOME} is not replaced" {
+		t.Errorf("escaped reference was replaced: %s", web.EntryPoint[1])
+	}
+	if web.Instances != 3 {
+		t.Errorf("expected Instances from the variables file, got %d", web.Instances)
+	}
+	if web.Expose["7070"] != 7070 {
+		t.Errorf("expected the port that was set, got %v", web.Expose)
+	}
+	if vars.effective()["Repo"] != "registry.example.com:5000/blah" {
+		t.Errorf("expected effective variables to be expanded: %v", vars.effective())
+	}
+}
+
+func TestVariablesUnresolved(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+
+	yamlExample := "Variables:\n  Tag: ${Tag}\nContainers:\n  - Repository: blah\n    Tag: ${Nope}\n"
+	_, err := NewConfigWithOptions(strings.NewReader(yamlExample), helper, cli, nil, nil)
+	if err == nil || err.Error() != "Pickett.yaml:5:10: no variable called Nope" {
+		t.Errorf("expected an unresolved variable, got %v", err)
+	}
+
+	yamlExample = strings.Replace(yamlExample, "${Nope}", "${Tag}", 1)
+	_, err = NewConfigWithOptions(strings.NewReader(yamlExample), helper, cli, nil, nil)
+	if err == nil || !strings.HasSuffix(err.Error(), "variable Tag refers to itself") {
+		t.Errorf("expected a variable that refers to itself, got %v", err)
+	}
+
+	_, err = NewConfigWithOptions(strings.NewReader(yamlExample), helper, cli, nil, &ConfigOptions{Set: []string{"Tag"}})
+	if err == nil || err.Error() != "can't set Tag, expected name=value" {
+		t.Errorf("expected a bad --set, got %v", err)
+	}
+
+	helper.EXPECT().OpenDockerfileRelative("mydir").Return(nil, nil)
+	c, err := NewConfigWithOptions(strings.NewReader(example1), helper, cli, nil, &ConfigOptions{Set: []string{"Tag=v1"}})
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	if c.variables.effective()["Tag"] != "v1" {
+		t.Errorf("expected Tag to be set: %v", c.variables.effective())
+	}
+}
@@ -0,0 +1,244 @@
+package io
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+//CopyArtifact says what to copy out of an image, or out of the source tree, and
+//where to put it.  SourcePath may be a glob pattern (as in path.Match).  A directory
+//that matches is copied with everything below it, like COPY in a Dockerfile: its
+//contents end up in DestinationDir.  With Flatten, all the files go directly into
+//DestinationDir.  Files whose path below DestinationDir, or any part of it, matches
+//one of the Excludes are left out.  DestinationName renames the one file that
+//matched.  Mode, if not zero, replaces the permissions of the files and, if Chown is
+//true, they belong to Uid and Gid.
+type CopyArtifact struct {
+	SourcePath, DestinationDir string
+	DestinationName            string
+	Excludes                   []string
+	Flatten                    bool
+	Mode                       os.FileMode
+	Chown                      bool
+	Uid, Gid                   int
+}
+
+// GlobRoot returns the part of the pattern p before the first path element that
+// has a wildcard in it.  Everything that p matches is below it.
+func GlobRoot(p string) string {
+	p = path.Clean(p)
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		if strings.ContainsAny(part, "*?[\\") {
+			root := strings.Join(parts[:i], "/")
+			if root == "" && strings.HasPrefix(p, "/") {
+				return "/"
+			}
+			if root == "" {
+				return "."
+			}
+			return root
+		}
+	}
+	return p
+}
+
+//target returns where the file full (a path in the same space as the SourcePath)
+//goes in the resulting image, or false if it is not part of the artifact.
+func (a *CopyArtifact) target(full string) (string, bool) {
+	pattern := path.Clean(a.SourcePath)
+	matched := path.Clean(full)
+	for {
+		if ok, _ := path.Match(pattern, matched); ok {
+			break
+		}
+		parent := path.Dir(matched)
+		if parent == matched {
+			return "", false
+		}
+		matched = parent
+	}
+	rel := path.Base(full)
+	if matched != path.Clean(full) && !a.Flatten {
+		rel = strings.TrimPrefix(path.Clean(full)[len(matched):], "/")
+	}
+	if a.excluded(rel) {
+		return "", false
+	}
+	if a.DestinationName != "" {
+		rel = a.DestinationName
+	}
+	return path.Join(a.DestinationDir, rel), true
+}
+
+//excluded is true if rel, or any of the directories leading to it, matches one of the
+//Excludes, either as a whole or by name.
+func (a *CopyArtifact) excluded(rel string) bool {
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+		for _, ex := range a.Excludes {
+			if ok, _ := path.Match(ex, prefix); ok {
+				return true
+			}
+			if ok, _ := path.Match(ex, parts[i]); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//fixHeader names the entry for dest and applies the mode and ownership of the artifact.
+func (a *CopyArtifact) fixHeader(hdr *tar.Header, dest string) {
+	hdr.Name = strings.TrimPrefix(dest, "/")
+	if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+		return
+	}
+	if a.Mode != 0 {
+		hdr.Mode = (hdr.Mode &^ 07777) | int64(a.Mode&07777)
+	}
+	if a.Chown {
+		hdr.Uid, hdr.Gid = a.Uid, a.Gid
+		hdr.Uname, hdr.Gname = "", ""
+	}
+}
+
+//artifactFileFunc is called with each file of an artifact.  The name in the header
+//is already where the file goes in the image (without the leading slash) and the file's
+//content can be read from r.
+type artifactFileFunc func(hdr *tar.Header, r io.Reader) error
+
+//eachArtifactFile calls fn for each file of the artifact a, which is either in the source
+//tree, at hostRoot on this machine, or, if hostRoot is empty, in the container cont.
+func (d *dockerCli) eachArtifactFile(a *CopyArtifact, hostRoot string, cont string, fn artifactFileFunc) error {
+	count := 0
+	counted := func(hdr *tar.Header, r io.Reader) error {
+		count++
+		if count > 1 && a.DestinationName != "" {
+			return fmt.Errorf("%s matches more than one file, so it can't be renamed to %s", a.SourcePath, a.DestinationName)
+		}
+		return fn(hdr, r)
+	}
+	var err error
+	if hostRoot != "" {
+		err = a.eachSourceFile(hostRoot, counted)
+	} else {
+		err = d.eachContainerFile(a, cont, counted)
+	}
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		flog.Warningf("artifact %s matched no files", a.SourcePath)
+	}
+	return nil
+}
+
+//eachSourceFile walks the files below hostRoot, which is where the root of the
+//artifact's pattern is on this machine.
+func (a *CopyArtifact) eachSourceFile(hostRoot string, fn artifactFileFunc) error {
+	root := GlobRoot(a.SourcePath)
+	return filepath.Walk(hostRoot, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(hostRoot, p)
+		if err != nil {
+			return err
+		}
+		dest, ok := a.target(path.Join(root, filepath.ToSlash(rel)))
+		if !ok {
+			return nil
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		//like COPY, files from the source tree belong to root unless we are told otherwise
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		a.fixHeader(hdr, dest)
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			return fn(hdr, bytes.NewReader(nil))
+		}
+		fp, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		flog.Debugf("adding %s from source tree as %s", p, hdr.Name)
+		return fn(hdr, fp)
+	})
+}
+
+//eachContainerFile copies the root of the artifact's pattern out of the container cont
+//and walks the files in it as they arrive.
+func (d *dockerCli) eachContainerFile(a *CopyArtifact, cont string, fn artifactFileFunc) error {
+	root := GlobRoot(a.SourcePath)
+	flog.Debugf("copying from container %s. Resource %s to %s", cont, root, a.DestinationDir)
+	pr, pw := io.Pipe()
+	copied := make(chan error, 1)
+	go func() {
+		err := d.client.CopyFromContainer(docker.CopyFromContainerOptions{
+			OutputStream: pw,
+			Container:    cont,
+			Resource:     root,
+		})
+		pw.CloseWithError(err)
+		copied <- err
+	}()
+	err := a.eachTarFile(tar.NewReader(pr), path.Dir(root), fn)
+	if err == nil {
+		//read what is left after the end of the tarball, so the copy can finish
+		_, err = io.Copy(ioutil.Discard, pr)
+	}
+	//if we gave up early, this stops the copy
+	pr.Close()
+	if copyErr := <-copied; err == nil {
+		err = copyErr
+	}
+	return err
+}
+
+//eachTarFile walks the files of the tarball, whose names are relative to dir.
+func (a *CopyArtifact) eachTarFile(tr *tar.Reader, dir string, fn artifactFileFunc) error {
+	for {
+		entry, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		dest, ok := a.target(path.Join(dir, entry.Name))
+		if !ok {
+			continue
+		}
+		flog.Debugf("read file from container: %s, going to %s", entry.Name, dest)
+		a.fixHeader(entry, dest)
+		if err := fn(entry, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -3,11 +3,13 @@ package io
 import (
 	"archive/tar"
 	"bytes"
+	"crypto/sha1"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -44,6 +46,9 @@ type RunConfig struct {
 	Links      map[string]string
 	Privileged bool
 	WaitOutput bool
+	Labels     map[string]string
+	WorkingDir string
+	Env        []string
 }
 
 type TagInfo struct {
@@ -51,25 +56,91 @@ type TagInfo struct {
 	Tag        string
 }
 
+//BuildConfig holds the options of a docker build.  If Dockerfile is not nil, it is
+//used instead of the Dockerfile in the directory being built.
 type BuildConfig struct {
 	NoCache                  bool
 	RemoveTemporaryContainer bool
+	Labels                   map[string]string
+	BuildArgs                map[string]string
+	Dockerfile               []byte
 }
 
-type CopyArtifact struct {
-	SourcePath, DestinationDir string
+//ImageConfig holds the settings that are applied to the configuration of an
+//image that pickett creates, either by committing a container or by copying
+//artifacts into it.
+type ImageConfig struct {
+	Labels       map[string]string
+	Entrypoint   []string
+	Cmd          []string
+	Env          []string
+	WorkingDir   string
+	User         string
+	ExposedPorts []string
+}
+
+//dockerConfig returns the docker configuration that has these settings.  It is
+//safe to call with a nil ImageConfig.
+func (c *ImageConfig) dockerConfig() *docker.Config {
+	result := &docker.Config{}
+	if c == nil {
+		return result
+	}
+	result.Labels = c.Labels
+	result.Entrypoint = c.Entrypoint
+	result.Cmd = c.Cmd
+	result.Env = c.Env
+	result.WorkingDir = c.WorkingDir
+	result.User = c.User
+	if len(c.ExposedPorts) != 0 {
+		result.ExposedPorts = make(map[docker.Port]struct{})
+		for _, p := range c.ExposedPorts {
+			result.ExposedPorts[docker.Port(p)] = struct{}{}
+		}
+	}
+	return result
+}
+
+type ServerVersion struct {
+	Version    string
+	ApiVersion string
+}
+
+//VolumeSync describes how to bring a docker volume up to date with a directory on
+//this machine.  Only the files modified after Since are sent to the daemon, unless
+//the set of files has changed from the one described by Manifest (as returned by
+//the previous sync) or the volume doesn't exist yet.  In that case, the volume is
+//emptied and everything is sent.
+type VolumeSync struct {
+	Volume    string
+	SourceDir string
+	Image     string
+	Since     time.Time
+	Manifest  string
+	Labels    map[string]string
+}
+
+//VolumeInfo is what we need to know about a docker volume.
+type VolumeInfo struct {
+	Name       string
+	Mountpoint string
 }
 
 type DockerCli interface {
 	CmdRun(*RunConfig, ...string) (*bytes.Buffer, string, error)
 	CmdTag(string, bool, *TagInfo) error
-	CmdCommit(string, *TagInfo) (string, error)
+	CmdCommit(string, *TagInfo, *ImageConfig) (string, error)
 	CmdBuild(*BuildConfig, string, string) error
-	//Copy actually does two different things: copies artifacts from the source tree into a tarball
-	//or copies artifacts from a container (given here as an image) into a tarball.  In both cases
-	//the resulting tarball is sent to the docker server for a build.
-	CmdCopy(map[string]string, string, string, []*CopyArtifact, string) error
+	//Copy makes an image from another with artifacts added, from the source tree and from a
+	//container (given here as an image).  A container is created from the destination image,
+	//the artifacts are uploaded into it as a tarball and it is committed.
+	CmdCopy(map[string]string, string, string, []*CopyArtifact, *TagInfo, *ImageConfig) error
 	CmdLastModTime(map[string]string, string, []*CopyArtifact) (time.Time, error)
+	//SyncVolume copies the source directory into a docker volume and returns the manifest
+	//of the files that are now in it and the number of files copied.
+	CmdSyncVolume(*VolumeSync) (string, int, error)
+	ListVolumes() ([]*VolumeInfo, error)
+	RemoveVolume(string) error
 	CmdStop(string) error
 	CmdRmContainer(string) error
 	CmdRmImage(string) error
@@ -77,12 +148,16 @@ type DockerCli interface {
 	InspectContainer(string) (InspectedContainer, error)
 	ListContainers() (apiContainers, error)
 	ListImages() (apiImages, error)
+	ServerVersion() (*ServerVersion, error)
 }
 
 type InspectedImage interface {
 	CreatedTime() time.Time
 	ID() string
 	ContainerID() string
+	Labels() map[string]string
+	Env() []string
+	WorkingDir() string
 }
 
 type InspectedContainer interface {
@@ -93,35 +168,73 @@ type InspectedContainer interface {
 	ExitStatus() int
 	Ip() string
 	Ports() []string
+	Labels() map[string]string
 }
 
 //NewDocker returns a connection to the docker server.  Pickett assumes that
-//the DockerCli is "passed in from the outside".
-func NewDockerCli() (DockerCli, error) {
-	if err := validateDockerHost(); err != nil {
+//the DockerCli is "passed in from the outside".  The endpoints should have
+//been resolved with ResolveEndpoints.
+func NewDockerCli(ep *Endpoints) (DockerCli, error) {
+	if _, err := normalizeDockerHost(ep.DockerHost, ep.useTLS()); err != nil {
 		return nil, err
 	}
-	return newDockerCli()
+	return newDockerCli(ep)
 }
 
 type dockerCli struct {
-	client *docker.Client
+	client     *docker.Client
+	apiVersion string
 }
 
 // newDockerCli builds a new docker interface and returns it. It
-// assumes that the DOCKER_HOST env var has already been
-// validated.
-func newDockerCli() (DockerCli, error) {
+// assumes that the endpoints have already been validated.  If no
+// API version is given, the newest one that both we and the daemon
+// understand is used.
+func newDockerCli(ep *Endpoints) (DockerCli, error) {
 	result := &dockerCli{}
 	var err error
-	result.client, err = docker.NewClient(os.Getenv("DOCKER_HOST"))
+	result.client, err = newDockerClient(ep, ep.APIVersion)
 	if err != nil {
 		return nil, err
 	}
-	flog.Debugf("[docker cmd] export DOCKER_HOST='%s'", os.Getenv("DOCKER_HOST"))
+	result.apiVersion = ep.APIVersion
+	if result.apiVersion == "" {
+		env, err := result.client.Version()
+		if err != nil {
+			return nil, err
+		}
+		have := env.Get("ApiVersion")
+		if !APIVersionAtLeast(have, MIN_API_VERSION) {
+			return nil, fmt.Errorf("docker daemon %s has API version %s, but pickett needs at least %s",
+				env.Get("Version"), have, MIN_API_VERSION)
+		}
+		result.apiVersion = have
+		if APIVersionAtLeast(have, MAX_API_VERSION) {
+			result.apiVersion = MAX_API_VERSION
+		}
+		result.client, err = newDockerClient(ep, result.apiVersion)
+		if err != nil {
+			return nil, err
+		}
+	}
+	flog.Debugf("[docker cmd] export DOCKER_HOST='%s'", ep.DockerHost)
+	if ep.useTLS() {
+		flog.Debugf("[docker cmd] export DOCKER_TLS_VERIFY=1 DOCKER_CERT_PATH='%s'", ep.CertPath)
+	}
+	flog.Debugf("[docker cmd] export DOCKER_API_VERSION='%s'", result.apiVersion)
 	return result, nil
 }
 
+//newDockerClient connects to the daemon given by ep, using tls if requested.  An
+//empty apiVersion means to use whatever the daemon considers the latest.
+func newDockerClient(ep *Endpoints, apiVersion string) (*docker.Client, error) {
+	if !ep.useTLS() {
+		return docker.NewVersionedClient(ep.DockerHost, apiVersion)
+	}
+	cert, key, ca := ep.tlsFiles()
+	return docker.NewVersionedTLSClient(ep.DockerHost, cert, key, ca, apiVersion)
+}
+
 func (d *dockerCli) createNamedContainer(config *docker.Config) (*docker.Container, error) {
 	tries := 0
 	ok := false
@@ -162,9 +275,19 @@ func (d *dockerCli) createNamedContainer(config *docker.Config) (*docker.Contain
 var EMPTY struct{}
 
 func (d *dockerCli) CmdRun(runconf *RunConfig, s ...string) (*bytes.Buffer, string, error) {
+	for k := range runconf.Volumes {
+		//anything but a path is the name of a docker volume
+		if !strings.HasPrefix(k, "/") && !APIVersionAtLeast(d.apiVersion, VOLUME_API_VERSION) {
+			return nil, "", fmt.Errorf("mounting docker volume %s needs docker API version %s, but we are using %s",
+				k, VOLUME_API_VERSION, d.apiVersion)
+		}
+	}
 	config := &docker.Config{}
 	config.Cmd = s
 	config.Image = runconf.Image
+	config.Labels = runconf.Labels
+	config.WorkingDir = runconf.WorkingDir
+	config.Env = runconf.Env
 
 	fordebug := new(bytes.Buffer)
 	cont, err := d.createNamedContainer(config)
@@ -172,6 +295,12 @@ func (d *dockerCli) CmdRun(runconf *RunConfig, s ...string) (*bytes.Buffer, stri
 		return nil, "", err
 	}
 	fordebug.WriteString(fmt.Sprintf("docker run %v ", cont.Name))
+	if runconf.WorkingDir != "" {
+		fordebug.WriteString(fmt.Sprintf("-w %s ", runconf.WorkingDir))
+	}
+	for _, e := range runconf.Env {
+		fordebug.WriteString(fmt.Sprintf("-e %s ", e))
+	}
 	host := &docker.HostConfig{}
 
 	//flatten links for consumption by go-dockerclient
@@ -204,7 +333,7 @@ func (d *dockerCli) CmdRun(runconf *RunConfig, s ...string) (*bytes.Buffer, stri
 		convertedMap[key] = []docker.PortBinding{}
 		for _, m := range v {
 			convertedMap[key] = append(convertedMap[key],
-				docker.PortBinding{HostIp: m.HostIp, HostPort: m.HostPort})
+				docker.PortBinding{HostIP: m.HostIp, HostPort: m.HostPort})
 			fordebug.WriteString(fmt.Sprintf("-p %s:%s:%s ", m.HostIp, m.HostPort, m.HostPort))
 		}
 	}
@@ -308,7 +437,7 @@ func (d *dockerCli) CmdTag(image string, force bool, info *TagInfo) error {
 	})
 }
 
-func (d *dockerCli) CmdCommit(containerId string, info *TagInfo) (string, error) {
+func (d *dockerCli) CmdCommit(containerId string, info *TagInfo, imgConf *ImageConfig) (string, error) {
 	opts := docker.CommitContainerOptions{
 		Container: containerId,
 	}
@@ -316,6 +445,10 @@ func (d *dockerCli) CmdCommit(containerId string, info *TagInfo) (string, error)
 		opts.Tag = info.Tag
 		opts.Repository = info.Repository
 	}
+	if imgConf != nil {
+		//docker merges this with the configuration of the container being committed
+		opts.Run = imgConf.dockerConfig()
+	}
 
 	flog.Debugf("[docker cmd] Commit of container. Options: Container: %s, Tag: %s, Repo: %s", opts.Container, opts.Tag, opts.Repository)
 
@@ -398,11 +531,12 @@ func (d *dockerCli) writeFullFile(tw *tar.Writer, path string, localName string)
 }
 
 //XXX is it safe to use /bin/true?
-func (d *dockerCli) makeDummyContainerToGetAtImage(img string) (string, error) {
+func (d *dockerCli) makeDummyContainerToGetAtImage(img string, labels map[string]string) (string, error) {
 	cont, err := d.client.CreateContainer(docker.CreateContainerOptions{
 		Config: &docker.Config{
 			Image:      img,
 			Entrypoint: []string{"/bin/true"},
+			Labels:     labels,
 		},
 	})
 	if err != nil {
@@ -417,7 +551,7 @@ func (d *dockerCli) CmdLastModTime(realPathSource map[string]string, img string,
 		flog.Debugln("no work to do in the container for last mod time, no artifacts inside it.")
 		return time.Time{}, nil
 	}
-	cont, err := d.makeDummyContainerToGetAtImage(img)
+	cont, err := d.makeDummyContainerToGetAtImage(img, nil)
 	if err != nil {
 		return time.Time{}, err
 	}
@@ -432,36 +566,15 @@ func (d *dockerCli) CmdLastModTime(realPathSource map[string]string, img string,
 		if found {
 			continue
 		}
-		//pull it from container
-		buf := new(bytes.Buffer)
-		flog.Debugf("copying from container %s. Resource %s to %s", cont, a.SourcePath, a.DestinationDir)
-
-		err = d.client.CopyFromContainer(docker.CopyFromContainerOptions{
-			OutputStream: buf,
-			Container:    cont,
-			Resource:     a.SourcePath,
+		err := d.eachArtifactFile(a, "", cont, func(hdr *tar.Header, r io.Reader) error {
+			if hdr.ModTime.After(best) {
+				best = hdr.ModTime
+			}
+			return nil
 		})
 		if err != nil {
 			return time.Time{}, err
 		}
-		//var out bytes.Buffer
-		r := bytes.NewReader(buf.Bytes())
-		tr := tar.NewReader(r)
-		for {
-			entry, err := tr.Next()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return time.Time{}, err
-			}
-			flog.Debugf("read file from container: %s, %v", entry.Name, entry.ModTime)
-			if !entry.FileInfo().IsDir() {
-				if entry.ModTime.After(best) {
-					best = entry.ModTime
-				}
-			}
-		}
 	}
 	return best, nil
 }
@@ -484,116 +597,299 @@ func hacky_poll(d *dockerCli) chan struct{} {
 	return term
 }
 
+//CmdCopy makes the image info from imgDest with the artifacts added, in a single layer,
+//and the settings of imgConf applied.  The artifacts are streamed, as a tarball, from
+//the source tree and from a container made from imgSrc straight into a container made
+//from imgDest, which is then committed.  With no artifacts, this just applies imgConf.
 func (d *dockerCli) CmdCopy(realPathSource map[string]string, imgSrc string, imgDest string,
-	artifacts []*CopyArtifact, resultTag string) error {
-	cont, err := d.makeDummyContainerToGetAtImage(imgSrc)
-	if err != nil {
-		return err
+	artifacts []*CopyArtifact, info *TagInfo, imgConf *ImageConfig) error {
+	if len(artifacts) != 0 && !APIVersionAtLeast(d.apiVersion, ARCHIVE_API_VERSION) {
+		return fmt.Errorf("extracting artifacts into %s:%s needs docker API version %s, but we are using %s",
+			info.Repository, info.Tag, ARCHIVE_API_VERSION, d.apiVersion)
 	}
-
+	cont := ""
 	if len(realPathSource) != len(artifacts) {
-		flog.Debugln("starting container because we need to retrieve artifacts from it")
-		//don't bother starting the container untless there is something we need from it
-		err = d.client.StartContainer(cont, &docker.HostConfig{})
+		//don't bother with a container unless there is something we need from it
+		src, err := d.makeDummyContainerToGetAtImage(imgSrc, nil)
 		if err != nil {
 			return err
 		}
+		defer d.removeQuietly(src)
+		cont = src
 	} else {
-		flog.Debugln("all artifacts found in source tree, not starting container")
+		flog.Debugln("all artifacts found in source tree, no container needed")
+	}
+
+	dest, run, err := d.makeContainerToCommit(imgDest, imgConf)
+	if err != nil {
+		return err
+	}
+	defer d.removeQuietly(dest)
+	if len(artifacts) != 0 {
+		if err := d.uploadArtifacts(realPathSource, cont, dest, artifacts); err != nil {
+			return err
+		}
 	}
 
-	dockerFile := new(bytes.Buffer)
-	resulTarball := new(bytes.Buffer)
-	tw := tar.NewWriter(resulTarball)
+	flog.Debugf("[docker cmd] Commit of container %s as %s:%s", dest, info.Repository, info.Tag)
+	_, err = d.client.CommitContainer(docker.CommitContainerOptions{
+		Container:  dest,
+		Repository: info.Repository,
+		Tag:        info.Tag,
+		Run:        run,
+	})
+	return err
+}
 
-	dockerFile.WriteString(fmt.Sprintf("FROM %s\n", imgDest))
+//uploadArtifacts streams the artifacts, from the source tree or the container cont,
+//into the container dest.
+func (d *dockerCli) uploadArtifacts(realPathSource map[string]string, cont string, dest string,
+	artifacts []*CopyArtifact) error {
 
-	//walk each artifact, potentially getting it from the container
-	for _, a := range artifacts {
-		truePath, found := realPathSource[a.SourcePath]
-		if found {
-			isFile, err := d.writeFullFile(tw, truePath, a.SourcePath)
-			if err != nil {
-				return err
-			}
-			//kinda hacky: we use a.SourcePath as the name *inside* the tarball so we can get the
-			//directory name right on the final output
-			flog.Debugf("COPY %s TO %s.", a.SourcePath, a.DestinationDir)
-			dockerFile.WriteString(fmt.Sprintf("COPY %s %s\n", a.SourcePath, a.DestinationDir))
-			if !isFile {
-				if err := d.tarball(truePath, a.SourcePath, tw); err != nil {
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		var err error
+		for _, a := range artifacts {
+			err = d.eachArtifactFile(a, realPathSource[a.SourcePath], cont, func(hdr *tar.Header, r io.Reader) error {
+				flog.Debugf("adding %s as /%s.", a.SourcePath, hdr.Name)
+				if err := tw.WriteHeader(hdr); err != nil {
 					return err
 				}
-			}
-		} else {
-			//pull it from container
-			buf := new(bytes.Buffer)
-			err = d.client.CopyFromContainer(docker.CopyFromContainerOptions{
-				OutputStream: buf,
-				Container:    cont,
-				Resource:     a.SourcePath,
+				_, err := io.Copy(tw, r)
+				return err
 			})
 			if err != nil {
-				return err
-			}
-			//var out bytes.Buffer
-			r := bytes.NewReader(buf.Bytes())
-			tr := tar.NewReader(r)
-			for {
-				entry, err := tr.Next()
-				if err == io.EOF {
-					break
-				}
-				if err != nil {
-					return err
-				}
-				flog.Debugf("read file from container: %s", entry.Name)
-				if !entry.FileInfo().IsDir() {
-					dockerFile.WriteString(fmt.Sprintf("COPY %s %s\n", entry.Name, a.DestinationDir+"/"+entry.Name))
-					if err := tw.WriteHeader(entry); err != nil {
-						return err
-					}
-					if _, err := io.Copy(tw, tr); err != nil {
-						return err
-					}
-				}
+				break
 			}
 		}
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	flog.Debugf("[docker cmd] Uploading artifacts to container %s", dest)
+	err := d.client.UploadToContainer(dest, docker.UploadToContainerOptions{
+		InputStream: pr,
+		Path:        "/",
+	})
+	//if the upload gave up early, this stops the writer
+	pr.Close()
+	return err
+}
+
+//makeContainerToCommit creates, but does not start, a container from img, with the
+//settings of imgConf, that artifacts can be copied into.  It returns the container and
+//the configuration to commit it with, so that the resulting image runs the way img does
+//unless imgConf says otherwise.  Docker won't create a container with no command, so if
+//there is none the container gets an entrypoint of /bin/true.  Docker fills in what the
+//commit configuration leaves out from the container, so that configuration has an empty,
+//rather than a missing, entrypoint to keep /bin/true out of the image.
+func (d *dockerCli) makeContainerToCommit(img string, imgConf *ImageConfig) (string, *docker.Config, error) {
+	insp, err := d.client.InspectImage(img)
+	if err != nil {
+		return "", nil, err
+	}
+	run := imgConf.dockerConfig()
+	if len(run.Cmd) == 0 && len(run.Entrypoint) == 0 && insp.Config != nil {
+		run.Cmd = insp.Config.Cmd
+		run.Entrypoint = insp.Config.Entrypoint
 	}
+	create := *run
+	create.Image = img
+	if len(create.Cmd) == 0 && len(create.Entrypoint) == 0 {
+		create.Entrypoint = []string{"/bin/true"}
+		run.Entrypoint = []string{}
+	}
+	cont, err := d.client.CreateContainer(docker.CreateContainerOptions{Config: &create})
+	if err != nil {
+		return "", nil, err
+	}
+	return cont.ID, run, nil
+}
 
-	hdr := &tar.Header{
-		Name: "Dockerfile",
-		Size: int64(dockerFile.Len()),
+//removeQuietly removes the helper container cont, only logging if that fails.
+func (d *dockerCli) removeQuietly(cont string) {
+	if err := d.CmdRmContainer(cont); err != nil {
+		flog.Warningf("unable to remove container %s: %v", cont, err)
 	}
-	if err := tw.WriteHeader(hdr); err != nil {
+}
+
+//labelInstruction returns a LABEL line for a Dockerfile that sets all the given
+//labels, or the empty string if there are none.
+func labelInstruction(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := []string{}
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", strconv.Quote(k), strconv.Quote(labels[k])))
+	}
+	return fmt.Sprintf("LABEL %s\n", strings.Join(pairs, " "))
+}
+
+//buildContext writes the build context in pathToDir to the tarball.  The Dockerfile
+//at the top of the context is replaced by dockerFile.
+func (d *dockerCli) buildContext(pathToDir string, dockerFile []byte, tw *tar.Writer) error {
+	dir, err := os.Open(pathToDir)
+	if err != nil {
 		return err
 	}
-	if _, err := io.Copy(tw, dockerFile); err != nil {
+	defer dir.Close()
+	names, err := dir.Readdirnames(0)
+	if err != nil {
 		return err
 	}
-	if err := tw.Close(); err != nil {
+	for _, name := range names {
+		if name == "Dockerfile" {
+			continue
+		}
+		path := filepath.Join(pathToDir, name)
+		isFile, err := d.writeFullFile(tw, path, name)
+		if err != nil {
+			return err
+		}
+		if !isFile {
+			if err := d.tarball(path, name, tw); err != nil {
+				return err
+			}
+		}
+	}
+	hdr := &tar.Header{
+		Name:    "Dockerfile",
+		Size:    int64(len(dockerFile)),
+		Mode:    0644,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
 		return err
 	}
+	_, err = tw.Write(dockerFile)
+	return err
+}
 
-	opts := docker.BuildImageOptions{
-		Name:           resultTag,
-		InputStream:    resulTarball,
-		OutputStream:   os.Stdout,
-		RmTmpContainer: true,
-		SuppressOutput: false,
-		NoCache:        true,
+//syncMount is where the volume being synced is mounted in the helper container.
+const syncMount = "/pickett-sync"
+
+//changedFiles walks pathToDir and returns the names (relative to pathToDir) of all
+//the files in it, sorted, and the subset of those modified after since.
+func changedFiles(pathToDir string, since time.Time) ([]string, []string, error) {
+	all := []string{}
+	changed := []string{}
+	err := filepath.Walk(pathToDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(pathToDir, path)
+		if err != nil {
+			return err
+		}
+		all = append(all, rel)
+		if info.ModTime().After(since) {
+			changed = append(changed, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
+	sort.Strings(all)
+	return all, changed, nil
+}
 
-	flog.Debugf("[docker cmd] Building image. Name: %s", opts.Name)
+func (d *dockerCli) CmdSyncVolume(sync *VolumeSync) (string, int, error) {
+	if !APIVersionAtLeast(d.apiVersion, VOLUME_API_VERSION) {
+		return "", 0, fmt.Errorf("copying code to volume %s needs docker API version %s, but we are using %s",
+			sync.Volume, VOLUME_API_VERSION, d.apiVersion)
+	}
+	all, changed, err := changedFiles(sync.SourceDir, sync.Since)
+	if err != nil {
+		return "", 0, err
+	}
+	manifest := fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(all, "\n"))))
+
+	//if the set of files is different, something was removed or renamed and the
+	//only safe thing is to start over
+	clean := manifest != sync.Manifest
+	if _, err := d.client.InspectVolume(sync.Volume); err != nil {
+		if err != docker.ErrNoSuchVolume {
+			return "", 0, err
+		}
+		flog.Debugf("[docker cmd] Creating volume %s", sync.Volume)
+		if _, err := d.client.CreateVolume(docker.CreateVolumeOptions{Name: sync.Volume}); err != nil {
+			return "", 0, err
+		}
+		clean = true
+	}
+	if clean {
+		changed = all
+	} else if len(changed) == 0 {
+		flog.Debugf("volume %s is up to date with %s", sync.Volume, sync.SourceDir)
+		return manifest, 0, nil
+	}
 
-	term := hacky_poll(d)
-	defer close(term)
+	entry := []string{"/bin/true"}
+	if clean {
+		entry = []string{"/bin/sh", "-c", fmt.Sprintf("rm -rf %s/..?* %s/.[!.]* %s/*", syncMount, syncMount, syncMount)}
+	}
+	host := &docker.HostConfig{
+		Binds: []string{fmt.Sprintf("%s:%s", sync.Volume, syncMount)},
+	}
+	cont, err := d.client.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{
+			Image:      sync.Image,
+			Entrypoint: entry,
+			Labels:     sync.Labels,
+		},
+		HostConfig: host,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	defer d.CmdRmContainer(cont.ID)
 
-	if err := d.client.BuildImage(opts); err != nil {
-		return err
+	if clean {
+		flog.Debugf("[docker cmd] Emptying volume %s", sync.Volume)
+		if err := d.client.StartContainer(cont.ID, host); err != nil {
+			return "", 0, err
+		}
+		status, err := d.client.WaitContainer(cont.ID)
+		if err != nil {
+			return "", 0, err
+		}
+		if status != 0 {
+			return "", 0, fmt.Errorf("Non-zero exitcode %v emptying volume %s", status, sync.Volume)
+		}
 	}
 
-	return nil
+	out := new(bytes.Buffer)
+	tw := tar.NewWriter(out)
+	for _, name := range changed {
+		if _, err := d.writeFullFile(tw, filepath.Join(sync.SourceDir, name), name); err != nil {
+			return "", 0, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", 0, err
+	}
+	flog.Debugf("[docker cmd] Copying %d files from %s to volume %s", len(changed), sync.SourceDir, sync.Volume)
+	err = d.client.UploadToContainer(cont.ID, docker.UploadToContainerOptions{
+		InputStream: out,
+		Path:        syncMount,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return manifest, len(changed), nil
 }
 
 func (d *dockerCli) CmdBuild(config *BuildConfig, pathToDir string, tag string) error {
@@ -601,7 +897,21 @@ func (d *dockerCli) CmdBuild(config *BuildConfig, pathToDir string, tag string)
 	//build tarball
 	out := new(bytes.Buffer)
 	tw := tar.NewWriter(out)
-	err := d.tarball(pathToDir, "", tw)
+	var err error
+	if len(config.Labels) == 0 && config.Dockerfile == nil {
+		err = d.tarball(pathToDir, "", tw)
+	} else {
+		//the labels are added by appending to the Dockerfile in the tarball
+		dockerFile := config.Dockerfile
+		if dockerFile == nil {
+			dockerFile, err = ioutil.ReadFile(filepath.Join(pathToDir, "Dockerfile"))
+			if err != nil {
+				return err
+			}
+		}
+		dockerFile = append(append([]byte{}, dockerFile...), []byte("\n"+labelInstruction(config.Labels))...)
+		err = d.buildContext(pathToDir, dockerFile, tw)
+	}
 	if err != nil {
 		return err
 	}
@@ -616,6 +926,20 @@ func (d *dockerCli) CmdBuild(config *BuildConfig, pathToDir string, tag string)
 		SuppressOutput: false,
 		NoCache:        config.NoCache,
 	}
+	if len(config.BuildArgs) != 0 {
+		if !APIVersionAtLeast(d.apiVersion, BUILD_ARGS_API_VERSION) {
+			return fmt.Errorf("build arguments for %s need docker API version %s, but we are using %s",
+				tag, BUILD_ARGS_API_VERSION, d.apiVersion)
+		}
+		names := []string{}
+		for name := range config.BuildArgs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			opts.BuildArgs = append(opts.BuildArgs, docker.BuildArg{Name: name, Value: config.BuildArgs[name]})
+		}
+	}
 
 	term := hacky_poll(d)
 	defer close(term)
@@ -654,7 +978,35 @@ func (d *dockerCli) ListContainers() (apiContainers, error) {
 }
 
 func (d *dockerCli) ListImages() (apiImages, error) {
-	return d.client.ListImages(true)
+	return d.client.ListImages(docker.ListImagesOptions{All: true})
+}
+
+func (d *dockerCli) ListVolumes() ([]*VolumeInfo, error) {
+	vols, err := d.client.ListVolumes(docker.ListVolumesOptions{})
+	if err != nil {
+		return nil, err
+	}
+	result := []*VolumeInfo{}
+	for _, v := range vols {
+		result = append(result, &VolumeInfo{Name: v.Name, Mountpoint: v.Mountpoint})
+	}
+	return result, nil
+}
+
+func (d *dockerCli) RemoveVolume(name string) error {
+	flog.Debugf("[docker cmd] Removing volume %s", name)
+	return d.client.RemoveVolume(name)
+}
+
+func (d *dockerCli) ServerVersion() (*ServerVersion, error) {
+	env, err := d.client.Version()
+	if err != nil {
+		return nil, err
+	}
+	return &ServerVersion{
+		Version:    env.Get("Version"),
+		ApiVersion: env.Get("ApiVersion"),
+	}, nil
 }
 
 //Wrappers for getting inspections
@@ -670,6 +1022,27 @@ func (i *imageInspect) ContainerID() string {
 	return i.wrapped.Container
 }
 
+func (i *imageInspect) Labels() map[string]string {
+	if i.wrapped.Config == nil {
+		return nil
+	}
+	return i.wrapped.Config.Labels
+}
+
+func (i *imageInspect) Env() []string {
+	if i.wrapped.Config == nil {
+		return nil
+	}
+	return i.wrapped.Config.Env
+}
+
+func (i *imageInspect) WorkingDir() string {
+	if i.wrapped.Config == nil {
+		return ""
+	}
+	return i.wrapped.Config.WorkingDir
+}
+
 func (c *contInspect) Ip() string {
 	return c.wrapped.NetworkSettings.IPAddress
 }
@@ -701,3 +1074,10 @@ func (c *contInspect) ContainerID() string {
 func (c *contInspect) ExitStatus() int {
 	return c.wrapped.State.ExitCode
 }
+
+func (c *contInspect) Labels() map[string]string {
+	if c.wrapped.Config == nil {
+		return nil
+	}
+	return c.wrapped.Config.Labels
+}
@@ -57,15 +57,15 @@ func (_mr *_MockDockerCliRecorder) CmdTag(arg0, arg1, arg2 interface{}) *gomock.
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdTag", arg0, arg1, arg2)
 }
 
-func (_m *MockDockerCli) CmdCommit(_param0 string, _param1 *TagInfo) (string, error) {
-	ret := _m.ctrl.Call(_m, "CmdCommit", _param0, _param1)
+func (_m *MockDockerCli) CmdCommit(_param0 string, _param1 *TagInfo, _param2 *ImageConfig) (string, error) {
+	ret := _m.ctrl.Call(_m, "CmdCommit", _param0, _param1, _param2)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-func (_mr *_MockDockerCliRecorder) CmdCommit(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdCommit", arg0, arg1)
+func (_mr *_MockDockerCliRecorder) CmdCommit(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdCommit", arg0, arg1, arg2)
 }
 
 func (_m *MockDockerCli) CmdBuild(_param0 *BuildConfig, _param1 string, _param2 string) error {
@@ -78,14 +78,14 @@ func (_mr *_MockDockerCliRecorder) CmdBuild(arg0, arg1, arg2 interface{}) *gomoc
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdBuild", arg0, arg1, arg2)
 }
 
-func (_m *MockDockerCli) CmdCopy(_param0 map[string]string, _param1 string, _param2 string, _param3 []*CopyArtifact, _param4 string) error {
-	ret := _m.ctrl.Call(_m, "CmdCopy", _param0, _param1, _param2, _param3, _param4)
+func (_m *MockDockerCli) CmdCopy(_param0 map[string]string, _param1 string, _param2 string, _param3 []*CopyArtifact, _param4 *TagInfo, _param5 *ImageConfig) error {
+	ret := _m.ctrl.Call(_m, "CmdCopy", _param0, _param1, _param2, _param3, _param4, _param5)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-func (_mr *_MockDockerCliRecorder) CmdCopy(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdCopy", arg0, arg1, arg2, arg3, arg4)
+func (_mr *_MockDockerCliRecorder) CmdCopy(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdCopy", arg0, arg1, arg2, arg3, arg4, arg5)
 }
 
 func (_m *MockDockerCli) CmdLastModTime(_param0 map[string]string, _param1 string, _param2 []*CopyArtifact) (time.Time, error) {
@@ -99,6 +99,39 @@ func (_mr *_MockDockerCliRecorder) CmdLastModTime(arg0, arg1, arg2 interface{})
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdLastModTime", arg0, arg1, arg2)
 }
 
+func (_m *MockDockerCli) CmdSyncVolume(_param0 *VolumeSync) (string, int, error) {
+	ret := _m.ctrl.Call(_m, "CmdSyncVolume", _param0)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+func (_mr *_MockDockerCliRecorder) CmdSyncVolume(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdSyncVolume", arg0)
+}
+
+func (_m *MockDockerCli) ListVolumes() ([]*VolumeInfo, error) {
+	ret := _m.ctrl.Call(_m, "ListVolumes")
+	ret0, _ := ret[0].([]*VolumeInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDockerCliRecorder) ListVolumes() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListVolumes")
+}
+
+func (_m *MockDockerCli) RemoveVolume(_param0 string) error {
+	ret := _m.ctrl.Call(_m, "RemoveVolume", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDockerCliRecorder) RemoveVolume(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RemoveVolume", arg0)
+}
+
 func (_m *MockDockerCli) CmdStop(_param0 string) error {
 	ret := _m.ctrl.Call(_m, "CmdStop", _param0)
 	ret0, _ := ret[0].(error)
@@ -173,6 +206,17 @@ func (_mr *_MockDockerCliRecorder) ListImages() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListImages")
 }
 
+func (_m *MockDockerCli) ServerVersion() (*ServerVersion, error) {
+	ret := _m.ctrl.Call(_m, "ServerVersion")
+	ret0, _ := ret[0].(*ServerVersion)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDockerCliRecorder) ServerVersion() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ServerVersion")
+}
+
 // Mock of InspectedImage interface
 type MockInspectedImage struct {
 	ctrl     *gomock.Controller
@@ -224,6 +268,36 @@ func (_mr *_MockInspectedImageRecorder) ContainerID() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "ContainerID")
 }
 
+func (_m *MockInspectedImage) Labels() map[string]string {
+	ret := _m.ctrl.Call(_m, "Labels")
+	ret0, _ := ret[0].(map[string]string)
+	return ret0
+}
+
+func (_mr *_MockInspectedImageRecorder) Labels() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Labels")
+}
+
+func (_m *MockInspectedImage) Env() []string {
+	ret := _m.ctrl.Call(_m, "Env")
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+func (_mr *_MockInspectedImageRecorder) Env() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Env")
+}
+
+func (_m *MockInspectedImage) WorkingDir() string {
+	ret := _m.ctrl.Call(_m, "WorkingDir")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+func (_mr *_MockInspectedImageRecorder) WorkingDir() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "WorkingDir")
+}
+
 // Mock of InspectedContainer interface
 type MockInspectedContainer struct {
 	ctrl     *gomock.Controller
@@ -314,3 +388,13 @@ func (_m *MockInspectedContainer) Ports() []string {
 func (_mr *_MockInspectedContainerRecorder) Ports() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Ports")
 }
+
+func (_m *MockInspectedContainer) Labels() map[string]string {
+	ret := _m.ctrl.Call(_m, "Labels")
+	ret0, _ := ret[0].(map[string]string)
+	return ret0
+}
+
+func (_mr *_MockInspectedContainerRecorder) Labels() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Labels")
+}
@@ -0,0 +1,74 @@
+package io
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestCopyIntoImageWithoutCommand(t *testing.T) {
+	var created, committed map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/images/"):
+			//like scratch or busybox, no CMD or ENTRYPOINT
+			w.Write([]byte(`{"Id": "abc", "Config": {}}`))
+		case r.Method == "POST" && r.URL.Path == "/containers/create":
+			json.NewDecoder(r.Body).Decode(&created)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id": "dest"}`))
+		case r.Method == "POST" && r.URL.Path == "/commit":
+			json.NewDecoder(r.Body).Decode(&committed)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id": "result"}`))
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatalf("can't make client: %v", err)
+	}
+	d := &dockerCli{client: client, apiVersion: ARCHIVE_API_VERSION}
+	if err := d.CmdCopy(nil, "src", "scratch", nil, &TagInfo{Repository: "result", Tag: "latest"}, nil); err != nil {
+		t.Fatalf("can't copy: %v", err)
+	}
+
+	//docker needs a command to create the container, but the image must not keep it
+	if created["Entrypoint"] == nil {
+		t.Errorf("expected the container to be created with an entrypoint: %v", created)
+	}
+	entry, ok := committed["Entrypoint"].([]interface{})
+	if !ok || len(entry) != 0 || committed["Cmd"] != nil {
+		t.Errorf("expected an empty entrypoint and no command to be committed, got %v and %v",
+			committed["Entrypoint"], committed["Cmd"])
+	}
+}
+
+func TestRunWithVolumeNeedsVolumeAPI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatalf("can't make client: %v", err)
+	}
+	d := &dockerCli{client: client, apiVersion: "1.20"}
+	runconf := &RunConfig{Image: "golang:1.16", Volumes: map[string]string{"pickett-cache-gomodcache": "/pickett/caches/gomodcache"}}
+	_, _, err = d.CmdRun(runconf, "go", "build")
+	if err == nil || !strings.Contains(err.Error(), "needs docker API version "+VOLUME_API_VERSION) {
+		t.Errorf("expected a named volume to need API version %s, got %v", VOLUME_API_VERSION, err)
+	}
+}
@@ -0,0 +1,231 @@
+package io
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//These are the environment variables that say how to reach the docker daemon and the
+//state store.  The docker ones mean the same thing they do to the docker client.
+const (
+	ENV_DOCKER_HOST = "DOCKER_HOST"
+	ENV_TLS_VERIFY  = "DOCKER_TLS_VERIFY"
+	ENV_CERT_PATH   = "DOCKER_CERT_PATH"
+	ENV_API_VERSION = "DOCKER_API_VERSION"
+	ENV_STATE_STORE = "PICKETT_STATE_STORE"
+)
+
+const (
+	DEFAULT_DOCKER_HOST     = "unix:///var/run/docker.sock"
+	DEFAULT_DOCKER_PORT     = "2375"
+	DEFAULT_DOCKER_TLS_PORT = "2376"
+	DEFAULT_ETCD_PORT       = "4001"
+)
+
+// Endpoints says where the docker daemon and the state store (etcd) are, and how to
+// talk to them.  StateStore is a comma separated list of etcd URLs; if it is empty,
+// etcd is assumed to be on the same host as the docker daemon.  If APIVersion is empty,
+// the version of the docker remote API is negotiated with the daemon.  TLSVerify is nil
+// when it isn't given, so that false can be given to turn it off.
+type Endpoints struct {
+	DockerHost string
+	TLSVerify  *bool
+	CertPath   string
+	APIVersion string
+	StateStore string
+}
+
+//envEndpoints returns the endpoints given by the environment.
+func envEndpoints() *Endpoints {
+	return &Endpoints{
+		DockerHost: os.Getenv(ENV_DOCKER_HOST),
+		TLSVerify:  envBool(ENV_TLS_VERIFY),
+		CertPath:   os.Getenv(ENV_CERT_PATH),
+		APIVersion: os.Getenv(ENV_API_VERSION),
+		StateStore: os.Getenv(ENV_STATE_STORE),
+	}
+}
+
+//envBool returns true if the environment variable name is set to anything, like the
+//docker client does, or nil if it isn't set.
+func envBool(name string) *bool {
+	if os.Getenv(name) == "" {
+		return nil
+	}
+	result := true
+	return &result
+}
+
+//firstSet returns the value of the first of the candidates that is not nil, or false.
+func firstSet(candidates ...*bool) bool {
+	for _, c := range candidates {
+		if c != nil {
+			return *c
+		}
+	}
+	return false
+}
+
+//firstNonEmpty returns the first of the candidates that is not empty.
+func firstNonEmpty(candidates ...string) string {
+	for _, c := range candidates {
+		if c = strings.TrimSpace(c); c != "" {
+			return c
+		}
+	}
+	return ""
+}
+
+// ResolveEndpoints combines the endpoints given on the command line, in the environment
+// and in the configuration file, in that order of precedence, fills in the defaults and
+// checks that the result makes sense.  Either argument may be nil.
+func ResolveEndpoints(flags *Endpoints, file *Endpoints) (*Endpoints, error) {
+	if flags == nil {
+		flags = &Endpoints{}
+	}
+	if file == nil {
+		file = &Endpoints{}
+	}
+	env := envEndpoints()
+	tls := firstSet(flags.TLSVerify, env.TLSVerify, file.TLSVerify)
+	result := &Endpoints{
+		DockerHost: firstNonEmpty(flags.DockerHost, env.DockerHost, file.DockerHost, DEFAULT_DOCKER_HOST),
+		TLSVerify:  &tls,
+		CertPath:   firstNonEmpty(flags.CertPath, env.CertPath, file.CertPath),
+		APIVersion: firstNonEmpty(flags.APIVersion, env.APIVersion, file.APIVersion),
+		StateStore: firstNonEmpty(flags.StateStore, env.StateStore, file.StateStore),
+	}
+
+	host, err := normalizeDockerHost(result.DockerHost, tls)
+	if err != nil {
+		return nil, fmt.Errorf("bad docker host %s: %v", result.DockerHost, err)
+	}
+	result.DockerHost = host
+
+	if tls {
+		if result.CertPath == "" {
+			result.CertPath = filepath.Join(os.Getenv("HOME"), ".docker")
+		} else if strings.HasPrefix(result.CertPath, "~") {
+			result.CertPath = os.Getenv("HOME") + result.CertPath[1:]
+		}
+	}
+
+	if result.APIVersion != "" && !APIVersionAtLeast(result.APIVersion, MIN_API_VERSION) {
+		return nil, fmt.Errorf("docker API version %s is too old, pickett needs at least %s", result.APIVersion, MIN_API_VERSION)
+	}
+
+	if result.StateStore == "" {
+		result.StateStore = defaultStateStore(result.DockerHost)
+	}
+	store, err := normalizeStateStore(result.StateStore)
+	if err != nil {
+		return nil, err
+	}
+	result.StateStore = store
+	return result, nil
+}
+
+//normalizeDockerHost checks that raw is a docker host that we can talk to and returns it
+//with the defaults filled in.  A host without a protocol is assumed to be tcp.  A tcp
+//host without a port gets the port docker uses by default, which depends on tls.
+func normalizeDockerHost(raw string, tls bool) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return DEFAULT_DOCKER_HOST, nil
+	}
+	proto := "tcp"
+	addr := raw
+	if i := strings.Index(raw, "://"); i != -1 {
+		proto = raw[:i]
+		addr = raw[i+len("://"):]
+	}
+	switch proto {
+	case "unix":
+		if addr == "" {
+			return DEFAULT_DOCKER_HOST, nil
+		}
+		if !filepath.IsAbs(addr) {
+			return "", BAD_DOCKER_HOST_FORMAT
+		}
+		return "unix://" + addr, nil
+	case "tcp":
+		addr = strings.TrimRight(addr, "/")
+		if strings.Contains(addr, "/") {
+			return "", BAD_DOCKER_HOST_FORMAT
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			port := DEFAULT_DOCKER_PORT
+			if tls {
+				port = DEFAULT_DOCKER_TLS_PORT
+			}
+			addr = net.JoinHostPort(strings.Trim(addr, "[]"), port)
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return "", BAD_DOCKER_HOST_FORMAT
+			}
+		}
+		return "tcp://" + addr, nil
+	}
+	return "", BAD_DOCKER_HOST_FORMAT
+}
+
+//defaultStateStore returns the etcd URL to use when none is given.  We assume
+//etcd is running on the same host as the docker daemon.
+func defaultStateStore(dockerHost string) string {
+	if !strings.HasPrefix(dockerHost, "tcp://") {
+		return "http://localhost:" + DEFAULT_ETCD_PORT
+	}
+	host, _, err := net.SplitHostPort(strings.TrimPrefix(dockerHost, "tcp://"))
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, DEFAULT_ETCD_PORT)
+}
+
+//normalizeStateStore checks the comma separated list of etcd endpoints, adding
+//http:// and the default port to any that don't have them.
+func normalizeStateStore(raw string) (string, error) {
+	result := []string{}
+	for _, ep := range strings.Split(raw, ",") {
+		ep = strings.TrimRight(strings.TrimSpace(ep), "/")
+		if ep == "" {
+			continue
+		}
+		if !strings.Contains(ep, "://") {
+			ep = "http://" + ep
+		}
+		if !strings.HasPrefix(ep, "http://") && !strings.HasPrefix(ep, "https://") {
+			return "", fmt.Errorf("bad state store %s, should be http://host[:port]", ep)
+		}
+		hostPort := ep[strings.Index(ep, "://")+len("://"):]
+		if strings.Contains(hostPort, "/") {
+			return "", fmt.Errorf("bad state store %s, should be http://host[:port]", ep)
+		}
+		if _, _, err := net.SplitHostPort(hostPort); err != nil {
+			ep = ep[:len(ep)-len(hostPort)] + net.JoinHostPort(strings.Trim(hostPort, "[]"), DEFAULT_ETCD_PORT)
+		}
+		result = append(result, ep)
+	}
+	if len(result) == 0 {
+		return "", fmt.Errorf("no state store given")
+	}
+	return strings.Join(result, ","), nil
+}
+
+//useTLS says whether to use tls to talk to the docker daemon.
+func (e *Endpoints) useTLS() bool {
+	return e.TLSVerify != nil && *e.TLSVerify
+}
+
+//stateStoreURLs returns the list of etcd URLs to connect to.
+func (e *Endpoints) stateStoreURLs() []string {
+	return strings.Split(e.StateStore, ",")
+}
+
+//tlsFiles returns the names of the client certificate, the client key and the
+//certificate authority used to talk to the docker daemon.
+func (e *Endpoints) tlsFiles() (string, string, string) {
+	return filepath.Join(e.CertPath, "cert.pem"), filepath.Join(e.CertPath, "key.pem"), filepath.Join(e.CertPath, "ca.pem")
+}
@@ -23,16 +23,21 @@ type etcdClient struct {
 	client *etcd.Client
 }
 
-func NewEtcdClient() (EtcdClient, error) {
+//NewEtcdClient returns a connection to the state store given by the endpoints,
+//which should have been resolved with ResolveEndpoints.
+func NewEtcdClient(ep *Endpoints) (EtcdClient, error) {
 	result := &etcdClient{
-		client: etcd.NewClient([]string{constructEctdHost()}),
+		client: etcd.NewClient(ep.stateStoreURLs()),
 	}
 	_, err := result.client.Get("/blah/blah/blah", false, false)
 	if err == nil {
 		panic("should not be able to retreive /blah/blah/blah")
 	}
 	/*fmt.Printf("ETCD SENT A RESULT! %v\n", err)*/
-	e := err.(*etcd.EtcdError)
+	e, ok := err.(*etcd.EtcdError)
+	if !ok {
+		return nil, err
+	}
 	if e.ErrorCode != 100 {
 		return nil, e
 	}
@@ -1,9 +1,13 @@
 package io
 
 import (
+	"bytes"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -17,6 +21,9 @@ type Helper interface {
 	ConfigFile() string
 	LastTimeInDirRelative(string) (time.Time, error)
 	LastTimeInDir(string) (time.Time, error)
+	SourceRevision() string
+	UserConfigReader() (io.Reader, error)
+	WriteFileRelative(path string, content []byte) error
 }
 
 // NewHelper creates an implementation of the Helper that runs against
@@ -40,10 +47,15 @@ type helper struct {
 	confFile   string
 }
 
-// OpenDockerfileRelative returns a reader connected to the Dockerfile
-// requsted in dir (relative to the Pickett.json) or an error.
+// OpenDockerfileRelative returns a reader of the content of the Dockerfile
+// requsted in dir (relative to the Pickett.json) or an error.  The file itself
+// is already closed.
 func (i *helper) OpenDockerfileRelative(dir string) (io.Reader, error) {
-	return os.Open(filepath.Join(i.DirectoryRelative(dir), "Dockerfile"))
+	content, err := ioutil.ReadFile(filepath.Join(i.DirectoryRelative(dir), "Dockerfile"))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(content), nil
 }
 
 // OpenFiles returns an *os.File connected to file path given, relative to the
@@ -84,6 +96,46 @@ func (i *helper) LastTimeInDir(fullPath string) (time.Time, error) {
 	return lastTimeInADirTree(fullPath, time.Time{})
 }
 
+// UserConfigReader returns a reader of the content of the user's configuration file,
+// ~/.pickett.json, or nil if the user doesn't have one.  The file itself is already
+// closed.
+func (i *helper) UserConfigReader() (io.Reader, error) {
+	if os.Getenv("HOME") == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(filepath.Join(os.Getenv("HOME"), ".pickett.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(content), nil
+}
+
+// WriteFileRelative writes content to the file path given, relative to the
+// configuration file, creating the directories needed to hold it.
+func (i *helper) WriteFileRelative(path string, content []byte) error {
+	full := filepath.Join(i.pickettDir, path)
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(full, content, 0644)
+}
+
+// SourceRevision returns the git revision of the source tree that holds the
+// configuration file, or the empty string if it can't be determined.
+func (i *helper) SourceRevision() string {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = i.pickettDir
+	out, err := cmd.Output()
+	if err != nil {
+		flog.Debugf("unable to find git revision of %s: %v", i.pickettDir, err)
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
 //lastTimeInADirTree recursively traverses a directory and looks for
 //the latest time it can find.
 func lastTimeInADirTree(path string, bestSoFar time.Time) (time.Time, error) {
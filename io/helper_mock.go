@@ -4,10 +4,10 @@
 package io
 
 import (
+	gomock "code.google.com/p/gomock/gomock"
 	io "io"
 	os "os"
 	time "time"
-	gomock "code.google.com/p/gomock/gomock"
 )
 
 // Mock of Helper interface
@@ -104,3 +104,34 @@ func (_m *MockHelper) LastTimeInDir(_param0 string) (time.Time, error) {
 func (_mr *_MockHelperRecorder) LastTimeInDir(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "LastTimeInDir", arg0)
 }
+
+func (_m *MockHelper) SourceRevision() string {
+	ret := _m.ctrl.Call(_m, "SourceRevision")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+func (_mr *_MockHelperRecorder) SourceRevision() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SourceRevision")
+}
+
+func (_m *MockHelper) UserConfigReader() (io.Reader, error) {
+	ret := _m.ctrl.Call(_m, "UserConfigReader")
+	ret0, _ := ret[0].(io.Reader)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockHelperRecorder) UserConfigReader() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UserConfigReader")
+}
+
+func (_m *MockHelper) WriteFileRelative(path string, content []byte) error {
+	ret := _m.ctrl.Call(_m, "WriteFileRelative", path, content)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockHelperRecorder) WriteFileRelative(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "WriteFileRelative", arg0, arg1)
+}
@@ -2,14 +2,13 @@ package io
 
 import (
 	"errors"
-	"fmt"
 	"os"
+	"strconv"
 	"strings"
 )
 
 var (
-	NO_DOCKER_HOST         = errors.New("no DOCKER_HOST found in environment, please set it")
-	BAD_DOCKER_HOST_FORMAT = errors.New("DOCKER_HOST found, but should be protocol://host:port")
+	BAD_DOCKER_HOST_FORMAT = errors.New("docker host should be tcp://host[:port] or unix:///path/to/socket")
 	BAD_INSPECT_RESULT     = errors.New("unable to understand result of docker inspect")
 )
 
@@ -17,45 +16,68 @@ const (
 	PICKETT_KEYSPACE = "/pickett/"
 )
 
-//splitProto returns two strings based on an expected value of DOCKER_HOST
-//that is foo://bar:baz.  It presumes you have already called validateDockerHost.
-func splitProto() []string {
-	if strings.Index(os.Getenv("DOCKER_HOST"), "://") == -1 {
-		return nil
-	}
-	return strings.Split(os.Getenv("DOCKER_HOST"), "://")
-}
+//MIN_API_VERSION is the oldest docker remote API that understands everything
+//pickett asks of the daemon (labels were introduced in 1.18).
+const MIN_API_VERSION = "1.18"
 
-//validateDockerHost checks the environment for a sensible value for DOCKER_HOST.
-func validateDockerHost() error {
-	raw := os.Getenv("DOCKER_HOST")
-	if raw == "" {
-		return NO_DOCKER_HOST
-	}
-	if strings.Index(raw, "://") == -1 {
-		return BAD_DOCKER_HOST_FORMAT
-	}
-	parts := strings.Split(raw, "://")
-	second := parts[1]
-	if len(strings.Split(second, ":")) != 2 {
-		if parts[0] == "unix" {
-			return nil
-		}
-		return BAD_DOCKER_HOST_FORMAT
-	}
-	return nil
+//MAX_API_VERSION is the newest docker remote API pickett knows about.  When
+//negotiating with a newer daemon, this is the version we ask for.
+const MAX_API_VERSION = "1.24"
+
+//ARCHIVE_API_VERSION is the oldest docker remote API that can upload a tarball into a
+//container, which is how extractions add their artifacts.
+const ARCHIVE_API_VERSION = "1.20"
+
+//BUILD_ARGS_API_VERSION is the oldest docker remote API that takes build arguments.
+const BUILD_ARGS_API_VERSION = "1.21"
+
+//VOLUME_API_VERSION is the oldest docker remote API with named volumes and the
+//archive endpoints, which are needed for code volumes in COPY mode.
+const VOLUME_API_VERSION = "1.21"
+
+//These are the docker labels that pickett puts on every container and image it
+//creates, so that the origin of an object can be recovered from docker alone.
+const (
+	LABEL_PROJECT  = "io.pickett.project"
+	LABEL_NODE     = "io.pickett.node"
+	LABEL_TYPE     = "io.pickett.type"
+	LABEL_TOPOLOGY = "io.pickett.topology"
+	LABEL_INSTANCE = "io.pickett.instance"
+	LABEL_CONFIG   = "io.pickett.config"
+	LABEL_REVISION = "io.pickett.revision"
+	LABEL_DIGEST   = "io.pickett.digest"
+)
+
+//LABEL_PREFIX starts all of pickett's labels; other labels must not use it.
+const LABEL_PREFIX = "io.pickett."
+
+//ValidateDockerHost checks the environment for a sensible value for DOCKER_HOST.
+//An empty DOCKER_HOST is fine, it means the default unix socket.
+func ValidateDockerHost() error {
+	_, err := normalizeDockerHost(os.Getenv(ENV_DOCKER_HOST), os.Getenv(ENV_TLS_VERIFY) != "")
+	return err
 }
 
-//construct ectd host from splitProto, assumes validateDockerHost already called
-func constructEctdHost() string {
-	pair := splitProto()
-	if pair[0] == "unix" {
-		return "http://localhost:4001"
-	}
-	hostPort := strings.Split(pair[1], ":")
-	if len(hostPort) != 2 {
-		fmt.Fprintf(os.Stderr, "your DOCKER_HOST is probably bogus (%s) and should be 'tcp://host:port'\n",
-			os.Getenv("DOCKER_HOST"))
+//APIVersionAtLeast returns true if the docker remote API version have is the
+//same or newer than want.  Versions are of the form "1.18".
+func APIVersionAtLeast(have string, want string) bool {
+	h := strings.Split(have, ".")
+	w := strings.Split(want, ".")
+	for i := 0; i < len(w); i++ {
+		if i >= len(h) {
+			return false
+		}
+		hv, err := strconv.Atoi(h[i])
+		if err != nil {
+			return false
+		}
+		wv, err := strconv.Atoi(w[i])
+		if err != nil {
+			return false
+		}
+		if hv != wv {
+			return hv > wv
+		}
 	}
-	return "http://" + hostPort[0] + ":4001"
+	return true
 }
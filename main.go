@@ -2,10 +2,12 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"syscall"
 
 	"github.com/igneous-systems/logit"
@@ -22,7 +24,16 @@ var (
 
 	// Global flags
 	debug      = app.Flag("debug", "Enable debug mode.").Short('d').Bool()
-	configFile = app.Flag("configFile", "Config file.").Short('f').Default("Pickett.json").String()
+	configFile = app.Flag("configFile", "Config file, in JSON, YAML or TOML (default Pickett.json, Pickett.yaml, Pickett.yml or Pickett.toml).").Short('f').String()
+	dockerHost = app.Flag("host", "Docker daemon, like tcp://host:2376 or unix:///var/run/docker.sock.").Short('H').String()
+	tlsVerify  = optionalBoolFlag(app.Flag("tlsverify", "Use TLS and verify the certificate of the docker daemon (--no-tlsverify to not)."))
+	certPath   = app.Flag("tlscertpath", "Directory holding ca.pem, cert.pem and key.pem for TLS.").String()
+	apiVersion = app.Flag("api-version", "Docker remote API version to use, instead of negotiating one.").String()
+	stateStore = app.Flag("state-store", "Etcd endpoints for pickett's state, comma separated.").String()
+	setVars    = app.Flag("set", "Set a variable used in the config file, like name=value.  May be repeated.").Strings()
+	varsFile   = app.Flag("vars-file", "File of variables used in the config file, in JSON, YAML or TOML.").String()
+	profile    = app.Flag("profile", "Profile of the topology entries to use, like ci.").String()
+	overlays   = app.Flag("overlay", "File that changes the entries of topologies, applied after the config file.  May be repeated.").Strings()
 
 	// Actions
 	run     = app.Command("run", "Runs a specific node in a topology, including all depedencies.")
@@ -58,8 +69,33 @@ var (
 	etcdSetVal = etcdSet.Arg("value", "Etcd value").Required().String()
 
 	destroy = app.Command("destroy", "Remove all containers and images, wipe etcd")
+
+	doctor    = app.Command("doctor", "Compare pickett's state store with docker and check the environment, offering repairs.")
+	doctorYes = doctor.Flag("yes", "Repair every problem that can be repaired without asking.").Short('y').Bool()
+
+	cache           = app.Command("cache", "Show the build caches and the builds that use them.")
+	cacheList       = cache.Command("list", "Show all or specified build caches.")
+	cacheListNames  = cacheList.Arg("caches", "Caches").Strings()
+	cacheClear      = cache.Command("clear", "Remove all or specified build caches.")
+	cacheClearNames = cacheClear.Arg("caches", "Caches").Strings()
+
+	configCmd        = app.Command("config", "Work with the configuration file.")
+	configConvert    = configCmd.Command("convert", "Translate the configuration file into another format.")
+	configConvertTo  = configConvert.Flag("to", "Format to translate to: json, yaml or toml.").String()
+	configConvertOut = configConvert.Arg("output", "File to write, in the format of its extension unless --to is given (default standard output).").String()
+	configShow       = configCmd.Command("show", "Print the configuration as pickett understands it, with defaults, absolute directories and edges.")
+	configShowRaw    = configShow.Flag("raw", "Print the configuration as written, with its variables replaced.").Bool()
+
+	schemaCmd = app.Command("schema", "Print a JSON Schema of the configuration file, for editors and linters.")
+	initCmd   = app.Command("init", "Write a configuration file for the Dockerfiles and go programs in this directory.")
+	initYes   = initCmd.Flag("yes", "Take every suggestion without asking.").Short('y').Bool()
+	validate  = app.Command("validate", "Check the configuration file, without docker or etcd.")
+	graph     = app.Command("graph", "Print the dependency graph of the configuration in graphviz DOT.")
 )
 
+//OFFLINE_ACTIONS are the commands that need the configuration but not docker or etcd.
+var OFFLINE_ACTIONS = []string{"validate", "graph", "config show"}
+
 func contains(s []string, target string) bool {
 	for _, candidate := range s {
 		if candidate == target {
@@ -69,20 +105,108 @@ func contains(s []string, target string) bool {
 	return false
 }
 
-func makeIOObjects(path string) (io.Helper, io.DockerCli, io.EtcdClient, error) {
+//optionalBool is a boolean flag that is nil unless it is given, so that --no-tlsverify
+//can turn off TLSVerify from the environment or the configuration file.
+type optionalBool struct {
+	value *bool
+}
+
+//optionalBoolFlag makes f an optionalBool.
+func optionalBoolFlag(f *kingpin.FlagClause) *optionalBool {
+	result := &optionalBool{}
+	f.SetValue(result)
+	return result
+}
+
+func (b *optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	b.value = &v
+	return nil
+}
+
+func (b *optionalBool) String() string {
+	if b.value == nil {
+		return ""
+	}
+	return strconv.FormatBool(*b.value)
+}
+
+func (b *optionalBool) IsBoolFlag() bool {
+	return true
+}
+
+func makeIOObjects(path string) (io.Helper, *io.Endpoints, error) {
 	helper, err := io.NewHelper(path)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("can't read %s: %v", path, err)
+		return nil, nil, fmt.Errorf("can't read %s: %v", path, err)
 	}
-	cli, err := io.NewDockerCli()
+	fromFile, err := pickett.ReadEndpoints(helper.ConfigReader())
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to connect to docker server, maybe its not running? %v", err)
+		return nil, nil, fmt.Errorf("can't understand endpoints in %s: %v", path, err)
+	}
+	fromFlags := &io.Endpoints{
+		DockerHost: *dockerHost,
+		TLSVerify:  tlsVerify.value,
+		CertPath:   *certPath,
+		APIVersion: *apiVersion,
+		StateStore: *stateStore,
 	}
-	etcd, err := io.NewEtcdClient()
+	ep, err := io.ResolveEndpoints(fromFlags, fromFile)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to connect to etcd, maybe its not running? %v", err)
+		return nil, nil, err
 	}
-	return helper, cli, etcd, nil
+	//everything else that looks at DOCKER_HOST (like path translation) should
+	//see the daemon we are actually talking to
+	os.Setenv(io.ENV_DOCKER_HOST, ep.DockerHost)
+	return helper, ep, nil
+}
+
+//findConfigFile returns the first of the usual configuration files that exists in the
+//current directory, or the first of them if none does.
+func findConfigFile() string {
+	for _, name := range pickett.CONFIG_FILES {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return pickett.CONFIG_FILES[0]
+}
+
+//convertConfig translates the configuration file into another format.  This needs
+//neither docker nor etcd.
+func convertConfig() int {
+	format := *configConvertTo
+	if format == "" {
+		format = pickett.FormatOfFile(*configConvertOut)
+	}
+	if format == "" {
+		fmt.Fprintf(os.Stderr, "give --to or an output file ending in .json, .yaml, .yml or .toml\n")
+		return 1
+	}
+	rd, err := os.Open(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer rd.Close()
+	out, err := pickett.ConvertConfig(rd, format)
+	if err != nil {
+		flog.Errorf("config convert: %v", err)
+		return 1
+	}
+	if *configConvertOut == "" {
+		os.Stdout.Write(out)
+		return 0
+	}
+	if err := ioutil.WriteFile(*configConvertOut, out, 0644); err != nil {
+		flog.Errorf("config convert: %v", err)
+		return 1
+	}
+	fmt.Printf("[pickett] wrote %s\n", *configConvertOut)
+	return 0
 }
 
 var flog = logit.NewNestedLoggerFromCaller(logit.Global)
@@ -122,17 +246,34 @@ func wrappedMain() int {
 	logit.Global.ModifyFilterLvl("stdout", logFilterLvl, nil, nil)
 	defer logit.Flush(-1)
 
-	if os.Getenv("DOCKER_HOST") == "" {
-		fmt.Fprintf(os.Stderr, "DOCKER_HOST not set; suggest DOCKER_HOST=tcp://:2375 (for local launcher)\n")
-		return 1
+	//the schema is the same for every configuration
+	if action == "schema" {
+		if err := pickett.CmdSchema(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		return 0
 	}
 
+	if *configFile == "" {
+		*configFile = findConfigFile()
+	}
+	if action == "init" {
+		if err := pickett.CmdInit(filepath.Dir(*configFile), filepath.Base(*configFile), *initYes, os.Stdin); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		return 0
+	}
 	_, err := os.Open(*configFile)
 	if err != nil {
 		wd, _ := os.Getwd()
 		fmt.Fprintf(os.Stderr, "%s not found (cwd: %s)\n", *configFile, wd)
 		return 1
 	}
+	if action == "config convert" {
+		return convertConfig()
+	}
 
 	absconf, err := filepath.Abs(*configFile)
 	if err != nil {
@@ -140,17 +281,66 @@ func wrappedMain() int {
 		return 1
 	}
 
-	helper, docker, etcd, err := makeIOObjects(absconf)
+	helper, endpoints, err := makeIOObjects(absconf)
 	if err != nil {
 		flog.Errorf("%v", err)
 		return 1
 	}
+	options := &pickett.ConfigOptions{Set: *setVars, Profile: *profile}
+	if *varsFile != "" {
+		rd, err := os.Open(*varsFile)
+		if err != nil {
+			flog.Errorf("can't read variables: %v", err)
+			return 1
+		}
+		defer rd.Close()
+		options.VariablesFile = rd
+	}
+	for _, name := range *overlays {
+		rd, err := os.Open(name)
+		if err != nil {
+			flog.Errorf("can't read overlay: %v", err)
+			return 1
+		}
+		defer rd.Close()
+		options.Overlays = append(options.Overlays, rd)
+	}
 	reader := helper.ConfigReader()
-	config, err := pickett.NewConfig(reader, helper, docker, etcd)
+	config, err := pickett.NewConfigWithOptions(reader, helper, nil, nil, options)
 	if err != nil {
-		flog.Errorf("Can't understand config file %s: %v", err.Error(), helper.ConfigFile())
+		flog.Errorf("Can't understand config file: %v", err)
+		return 1
+	}
+
+	//these only need the configuration, so they work without docker or etcd
+	if contains(OFFLINE_ACTIONS, action) {
+		switch action {
+		case "validate":
+			err = pickett.CmdValidate(config)
+		case "graph":
+			err = pickett.CmdGraph(config)
+		case "config show":
+			err = pickett.CmdConfigShow(config, *configShowRaw)
+		}
+		if err != nil {
+			flog.Errorf("%s: %v", action, err)
+			return 1
+		}
+		return 0
+	}
+
+	docker, err := io.NewDockerCli(endpoints)
+	if err != nil {
+		flog.Errorf("failed to connect to docker server %s, maybe its not running? %v", endpoints.DockerHost, err)
+		return 1
+	}
+	//the doctor can do useful work even when etcd is not reachable
+	etcd, etcdErr := io.NewEtcdClient(endpoints)
+	if etcdErr != nil && action != "doctor" {
+		flog.Errorf("failed to connect to etcd at %s, maybe its not running? %v", endpoints.StateStore, etcdErr)
 		return 1
 	}
+	config.Connect(docker, etcd)
 
 	returnCode := 0
 	switch action {
@@ -185,6 +375,14 @@ func wrappedMain() int {
 		}
 	case "destroy":
 		err = pickett.CmdDestroy(config)
+	case "doctor":
+		err = pickett.CmdDoctor(*doctorYes, etcdErr, config)
+	case "cache":
+		err = pickett.CmdCache(false, nil, config)
+	case "cache list":
+		err = pickett.CmdCache(false, *cacheListNames, config)
+	case "cache clear":
+		err = pickett.CmdCache(true, *cacheClearNames, config)
 	default:
 		app.Usage(os.Stderr)
 		return 1
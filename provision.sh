@@ -27,11 +27,9 @@ kill -9 $PID
 
 sudo cp bundles/1.1.2-dev/binary/docker /usr/bin/docker
 
-# build and install go
+# install go, at the GoVersion in Godeps
 cd /usr/local
-hg clone -u go1.3 https://code.google.com/p/go
-cd go/src
-./all.bash
+curl -sSL https://dl.google.com/go/go1.8.linux-amd64.tar.gz | tar -xz
 
 for i in go gofmt; do
     ln -s /usr/local/go/bin/$i /usr/local/bin/